@@ -24,7 +24,7 @@ _init() {
     ## Minimum required versions for build dependencies
     GIT_VERSION="1.0"
     CMAKE_VERSION="2.8"
-    GO_VERSION="1.7.1"
+    GO_VERSION="1.8"
     OSX_VERSION="10.8"
     KNAME=$(uname -s)
     ARCH=$(uname -m)
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"encoding/binary"
 	"flag"
 	"fmt"
 	"log"
@@ -17,6 +18,7 @@ import (
 )
 
 var addr = flag.String("addr", "localhost:8080", "http service address")
+var clientID = flag.String("client", "client-example", "client uuid sent in frame records")
 
 func readChoice(s string) int {
 	var i int
@@ -32,6 +34,32 @@ func readChoice(s string) int {
 	return i
 }
 
+// 8 bit Bayer formats, frames in these formats are sent as raw
+// frames and demosaiced by the server.
+var bayerFormats = map[webcam.PixelFormat]bool{
+	'G' | 'R'<<8 | 'B'<<16 | 'G'<<24: true, // V4L2_PIX_FMT_SGRBG8
+	'G' | 'B'<<8 | 'R'<<16 | 'G'<<24: true, // V4L2_PIX_FMT_SGBRG8
+	'R' | 'G'<<8 | 'G'<<16 | 'B'<<24: true, // V4L2_PIX_FMT_SRGGB8
+	'B' | 'A'<<8 | '8'<<16 | '1'<<24: true, // V4L2_PIX_FMT_SBGGR8
+}
+
+// Prefixes a frame with the raw frame header expected by the server.
+func rawFrame(format webcam.PixelFormat, w, h uint32, frame []byte) []byte {
+	data := make([]byte, 12, 12+len(frame))
+	copy(data, "XRAW")
+	binary.LittleEndian.PutUint32(data[4:], uint32(format))
+	binary.LittleEndian.PutUint16(data[8:], uint16(w))
+	binary.LittleEndian.PutUint16(data[10:], uint16(h))
+	return append(data, frame...)
+}
+
+// Returns the frame record describing a binary frame, the server
+// analyzes a binary frame only with the record sent right after it.
+func frameRecord(id int, w, h uint32) []byte {
+	return []byte(fmt.Sprintf(`{"client_uuid": %q, "frame": {"id": "%d", "width": "%d", "height": "%d"}}`,
+		*clientID, id, w, h))
+}
+
 type FrameSizes []webcam.FrameSize
 
 func (slice FrameSizes) Len() int {
@@ -143,6 +171,9 @@ func main() {
 			if ferr != nil {
 				panic(ferr.Error())
 			}
+			if bayerFormats[f] {
+				frame = rawFrame(f, w, h, frame)
+			}
 
 			select {
 			case frameData <- frame:
@@ -165,10 +196,15 @@ func main() {
 		}
 	}()
 
+	frameID := 0
 	for {
 		select {
 		case data := <-frameData:
+			frameID++
 			merr := c.WriteMessage(websocket.BinaryMessage, data)
+			if merr == nil {
+				merr = c.WriteMessage(websocket.TextMessage, frameRecord(frameID, w, h))
+			}
 			if merr != nil {
 				log.Println("write:", merr)
 				return
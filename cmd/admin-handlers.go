@@ -0,0 +1,379 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	router "github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// Maximum size of face images uploaded for enrolment.
+const maxEnrollImageSize = 8 << 20
+
+// Returns the token required to access the admin API, the admin
+// API is disabled if no token is configured.
+func getAdminToken() string {
+	return os.Getenv("ADMIN_TOKEN")
+}
+
+// adminClientInfo is the JSON representation of a single client,
+// sessions of all the connections carrying the same client id are
+// listed together.
+type adminClientInfo struct {
+	ClientID      string                   `json:"clientId"`
+	Sessions      []sessionInfo            `json:"sessions"`
+	MotionHistory map[string]motionHistory `json:"motionHistory,omitempty"` // Per object class.
+
+	// Server side face detections per second.
+	DetectionRate float64 `json:"detectionRate"`
+}
+
+// adminError is the JSON error returned by the admin API.
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// Writes v as the JSON response with the given status code.
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	errorIf(json.NewEncoder(w).Encode(v), "Unable to write admin response.")
+}
+
+// Wraps an admin handler with bearer token authentication.
+func adminAuth(token string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+			writeAdminJSON(w, http.StatusUnauthorized, adminError{"Invalid or missing admin token"})
+			return
+		}
+		h(w, r)
+	}
+}
+
+// Returns all the connections belonging to clientID.
+func (v *xrayHandlers) connsForClient(clientID string) []*wConn {
+	v.RLock()
+	defer v.RUnlock()
+	var conns []*wConn
+	for wc := range v.conns {
+		if wc.session.ClientID() == clientID {
+			conns = append(conns, wc)
+		}
+	}
+	return conns
+}
+
+// ListClientsHandler lists all the active client sessions.
+func (v *xrayHandlers) ListClientsHandler(w http.ResponseWriter, r *http.Request) {
+	v.RLock()
+	sessions := make([]sessionInfo, 0, len(v.conns))
+	for wc := range v.conns {
+		sessions = append(sessions, wc.session.Info())
+	}
+	v.RUnlock()
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ConnID < sessions[j].ConnID
+	})
+	writeAdminJSON(w, http.StatusOK, sessions)
+}
+
+// GetClientHandler shows the sessions and motion history of a client.
+func (v *xrayHandlers) GetClientHandler(w http.ResponseWriter, r *http.Request) {
+	clientID := router.Vars(r)["client"]
+	conns := v.connsForClient(clientID)
+	if len(conns) == 0 {
+		writeAdminJSON(w, http.StatusNotFound, adminError{"Client not connected"})
+		return
+	}
+
+	info := adminClientInfo{ClientID: clientID}
+	for _, wc := range conns {
+		info.Sessions = append(info.Sessions, wc.session.Info())
+	}
+	info.MotionHistory = make(map[string]motionHistory)
+	for _, class := range getRecordedClassesForClient(clientID) {
+		if mr, ok := lookupRecordForClient(clientID, class); ok {
+			info.MotionHistory[class] = mr.History()
+		}
+	}
+	if s, ok := lookupDetectionSchedulerForClient(clientID); ok {
+		info.DetectionRate = s.Rate(time.Now())
+	}
+	writeAdminJSON(w, http.StatusOK, info)
+}
+
+// DisconnectClientHandler closes all the connections of a client.
+func (v *xrayHandlers) DisconnectClientHandler(w http.ResponseWriter, r *http.Request) {
+	clientID := router.Vars(r)["client"]
+	conns := v.connsForClient(clientID)
+	if len(conns) == 0 {
+		writeAdminJSON(w, http.StatusNotFound, adminError{"Client not connected"})
+		return
+	}
+	for _, wc := range conns {
+		wc.log.Info("Disconnecting client on admin request")
+		msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "disconnected by operator")
+		errorIf(wc.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)),
+			"Unable to send close frame to client.")
+		// Closing the connection unblocks the read loop in Detect,
+		// which then unregisters the connection.
+		errorIf(wc.Close(), "Unable to close client connection.")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ResetClientHandler clears the motion history and session
+// counters of a client.
+func (v *xrayHandlers) ResetClientHandler(w http.ResponseWriter, r *http.Request) {
+	clientID := router.Vars(r)["client"]
+	resetRecordForClient(clientID)
+	resetFramerForClient(clientID)
+	resetZoomControllerForClient(clientID)
+	resetBarcodeDeduperForClient(clientID)
+	resetBestShotScorerForClient(clientID)
+	resetDetectionSchedulerForClient(clientID)
+	globalMetrics.DeleteClient(clientID)
+	for _, wc := range v.connsForClient(clientID) {
+		wc.log.Info("Resetting client on admin request")
+		wc.session.reset()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SendCommandHandler pushes a camera command to all the connections
+// of a client, with ?wait=true the response is delayed until every
+// connection acknowledged the command or it timed out.
+func (v *xrayHandlers) SendCommandHandler(w http.ResponseWriter, r *http.Request) {
+	clientID := router.Vars(r)["client"]
+	conns := v.connsForClient(clientID)
+	if len(conns) == 0 {
+		writeAdminJSON(w, http.StatusNotFound, adminError{"Client not connected"})
+		return
+	}
+
+	var cmd XrayCommand
+	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+		writeAdminJSON(w, http.StatusBadRequest, adminError{err.Error()})
+		return
+	}
+	if err := validateCommand(cmd); err != nil {
+		writeAdminJSON(w, http.StatusBadRequest, adminError{err.Error()})
+		return
+	}
+
+	var sent []*commandStatus
+	var sentConns []*wConn
+	for _, wc := range conns {
+		cs, err := wc.SendCommand(cmd)
+		if err != nil {
+			continue
+		}
+		sent = append(sent, cs)
+		sentConns = append(sentConns, wc)
+	}
+	if len(sent) == 0 {
+		writeAdminJSON(w, http.StatusBadGateway, adminError{"Unable to send command to client"})
+		return
+	}
+
+	statuses := make([]commandStatus, len(sent))
+	wait := r.URL.Query().Get("wait") == "true"
+	for i, cs := range sent {
+		if wait {
+			statuses[i] = sentConns[i].WaitCommand(cs)
+		} else {
+			statuses[i] = sentConns[i].commands.status(cs)
+		}
+	}
+	if wait {
+		writeAdminJSON(w, http.StatusOK, statuses)
+		return
+	}
+	writeAdminJSON(w, http.StatusAccepted, statuses)
+}
+
+// ListCommandsHandler lists pending and recent commands of a client.
+func (v *xrayHandlers) ListCommandsHandler(w http.ResponseWriter, r *http.Request) {
+	clientID := router.Vars(r)["client"]
+	conns := v.connsForClient(clientID)
+	if len(conns) == 0 {
+		writeAdminJSON(w, http.StatusNotFound, adminError{"Client not connected"})
+		return
+	}
+	statuses := []commandStatus{}
+	for _, wc := range conns {
+		statuses = append(statuses, wc.commands.List()...)
+	}
+	writeAdminJSON(w, http.StatusOK, statuses)
+}
+
+// ListEventsHandler lists the most recent events, optionally
+// filtered by ?client=<client id>.
+func (v *xrayHandlers) ListEventsHandler(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, globalEventLog.Recent(r.URL.Query().Get("client")))
+}
+
+// GetPolicyHandler shows the snapshot policy of a client.
+func (v *xrayHandlers) GetPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, getPolicyForClient(router.Vars(r)["client"]))
+}
+
+// SetPolicyHandler replaces the snapshot policy of a client, clients
+// need not be connected.
+func (v *xrayHandlers) SetPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	var policy clientPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		writeAdminJSON(w, http.StatusBadRequest, adminError{err.Error()})
+		return
+	}
+	if err := policy.validate(); err != nil {
+		writeAdminJSON(w, http.StatusBadRequest, adminError{err.Error()})
+		return
+	}
+	setPolicyForClient(router.Vars(r)["client"], policy)
+	writeAdminJSON(w, http.StatusOK, policy)
+}
+
+// DeletePolicyHandler restores the default policy of a client.
+func (v *xrayHandlers) DeletePolicyHandler(w http.ResponseWriter, r *http.Request) {
+	resetPolicyForClient(router.Vars(r)["client"])
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetDetectorHandler shows the face detector configuration of a client.
+func (v *xrayHandlers) GetDetectorHandler(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, getDetectorForClient(router.Vars(r)["client"]))
+}
+
+// SetDetectorHandler replaces the face detector configuration of a
+// client, clients need not be connected. The configuration is
+// dropped once the last connection of the client closes.
+func (v *xrayHandlers) SetDetectorHandler(w http.ResponseWriter, r *http.Request) {
+	var config detectorConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeAdminJSON(w, http.StatusBadRequest, adminError{err.Error()})
+		return
+	}
+	if err := config.validate(); err != nil {
+		writeAdminJSON(w, http.StatusBadRequest, adminError{err.Error()})
+		return
+	}
+	setDetectorForClient(router.Vars(r)["client"], config)
+	writeAdminJSON(w, http.StatusOK, config)
+}
+
+// DeleteDetectorHandler restores the default face detector
+// configuration of a client.
+func (v *xrayHandlers) DeleteDetectorHandler(w http.ResponseWriter, r *http.Request) {
+	resetDetectorForClient(router.Vars(r)["client"])
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListGalleryHandler lists the persons enrolled in the face gallery.
+func (v *xrayHandlers) ListGalleryHandler(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, globalFaceGallery.List())
+}
+
+// EnrollPersonHandler enrolls a face for a person, the request body
+// is a face crop as uploaded with snapshots and the name of the
+// person is set with ?name=<name>.
+func (v *xrayHandlers) EnrollPersonHandler(w http.ResponseWriter, r *http.Request) {
+	personID := router.Vars(r)["person"]
+	if personID == unknownIdentity {
+		writeAdminJSON(w, http.StatusBadRequest, adminError{"Reserved person id"})
+		return
+	}
+
+	img, _, err := image.Decode(io.LimitReader(r.Body, maxEnrollImageSize))
+	if err != nil {
+		writeAdminJSON(w, http.StatusBadRequest, adminError{err.Error()})
+		return
+	}
+	si, ok := img.(subImager)
+	face := unpadRect(img.Bounds(), faceCropPadding)
+	if !ok || face.Dx() < descriptorCellSize || face.Dy() < descriptorCellSize {
+		writeAdminJSON(w, http.StatusBadRequest, adminError{"Unsupported face image"})
+		return
+	}
+
+	info, err := globalFaceGallery.Enroll(personID, r.URL.Query().Get("name"), faceDescriptor(si.SubImage(face)))
+	if err != nil {
+		errorIf(err, "Unable to save face gallery.")
+		writeAdminJSON(w, http.StatusInternalServerError, adminError{"Unable to save face gallery"})
+		return
+	}
+	writeAdminJSON(w, http.StatusCreated, info)
+}
+
+// DeletePersonHandler removes a person from the face gallery.
+func (v *xrayHandlers) DeletePersonHandler(w http.ResponseWriter, r *http.Request) {
+	found, err := globalFaceGallery.Delete(router.Vars(r)["person"])
+	if err != nil {
+		errorIf(err, "Unable to save face gallery.")
+		writeAdminJSON(w, http.StatusInternalServerError, adminError{"Unable to save face gallery"})
+		return
+	}
+	if !found {
+		writeAdminJSON(w, http.StatusNotFound, adminError{"Person not enrolled"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Register admin router.
+func registerAdminRouter(mux *router.Router, xray *xrayHandlers) {
+	token := getAdminToken()
+	if token == "" {
+		rlog.Info("ADMIN_TOKEN is not set, admin API is disabled.")
+		return
+	}
+
+	adminRouter := mux.NewRoute().PathPrefix("/admin/v1").Subrouter()
+	adminRouter.Methods("GET").Path("/clients").HandlerFunc(adminAuth(token, xray.ListClientsHandler))
+	adminRouter.Methods("GET").Path("/clients/{client}").HandlerFunc(adminAuth(token, xray.GetClientHandler))
+	adminRouter.Methods("POST").Path("/clients/{client}/disconnect").HandlerFunc(adminAuth(token, xray.DisconnectClientHandler))
+	adminRouter.Methods("POST").Path("/clients/{client}/reset").HandlerFunc(adminAuth(token, xray.ResetClientHandler))
+	adminRouter.Methods("GET").Path("/clients/{client}/commands").HandlerFunc(adminAuth(token, xray.ListCommandsHandler))
+	adminRouter.Methods("POST").Path("/clients/{client}/commands").HandlerFunc(adminAuth(token, xray.SendCommandHandler))
+	adminRouter.Methods("GET").Path("/clients/{client}/policy").HandlerFunc(adminAuth(token, xray.GetPolicyHandler))
+	adminRouter.Methods("PUT").Path("/clients/{client}/policy").HandlerFunc(adminAuth(token, xray.SetPolicyHandler))
+	adminRouter.Methods("DELETE").Path("/clients/{client}/policy").HandlerFunc(adminAuth(token, xray.DeletePolicyHandler))
+	adminRouter.Methods("GET").Path("/clients/{client}/detector").HandlerFunc(adminAuth(token, xray.GetDetectorHandler))
+	adminRouter.Methods("PUT").Path("/clients/{client}/detector").HandlerFunc(adminAuth(token, xray.SetDetectorHandler))
+	adminRouter.Methods("DELETE").Path("/clients/{client}/detector").HandlerFunc(adminAuth(token, xray.DeleteDetectorHandler))
+	adminRouter.Methods("GET").Path("/events").HandlerFunc(adminAuth(token, xray.ListEventsHandler))
+	adminRouter.Methods("GET").Path("/gallery").HandlerFunc(adminAuth(token, xray.ListGalleryHandler))
+	adminRouter.Methods("POST").Path("/gallery/{person}").HandlerFunc(adminAuth(token, xray.EnrollPersonHandler))
+	adminRouter.Methods("DELETE").Path("/gallery/{person}").HandlerFunc(adminAuth(token, xray.DeletePersonHandler))
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"image"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	router "github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const testAdminToken = "secret"
+
+// Starts an xray server with the admin API enabled, returns the
+// handlers and the base URL serving both websocket and admin routes.
+func newAdminTestServer(t *testing.T, token string) (*xrayHandlers, *httptest.Server) {
+	prev := os.Getenv("ADMIN_TOKEN")
+	os.Setenv("ADMIN_TOKEN", token)
+	defer os.Setenv("ADMIN_TOKEN", prev)
+
+	xray := newXRayHandlers(nil)
+	mux := router.NewRouter()
+	registerAdminRouter(mux, xray)
+	mux.Path("/").HandlerFunc(xray.Detect)
+	return xray, httptest.NewServer(mux)
+}
+
+// Sends an admin request with the given bearer token.
+func adminRequest(t *testing.T, method, url, token string) *http.Response {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// Connects a websocket client reporting clientID, returns the client
+// and its connection on the server.
+func connectAdminTestClient(t *testing.T, xray *xrayHandlers, srv *httptest.Server, clientID string) (*websocket.Conn, *wConn) {
+	clnt, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/", nil)
+	if err != nil {
+		t.Fatalf("Unable to dial xray server: %v", err)
+	}
+	for {
+		xray.RLock()
+		for wc := range xray.conns {
+			if wc.session.ClientID() == "" {
+				wc.session.recordFrame(clientID)
+				xray.RUnlock()
+				return clnt, wc
+			}
+		}
+		xray.RUnlock()
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAdminAuth(t *testing.T) {
+	_, srv := newAdminTestServer(t, testAdminToken)
+	defer srv.Close()
+
+	testCases := []struct {
+		token  string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"wrong", http.StatusUnauthorized},
+		{testAdminToken, http.StatusOK},
+	}
+	for i, testCase := range testCases {
+		resp := adminRequest(t, "GET", srv.URL+"/admin/v1/clients", testCase.token)
+		resp.Body.Close()
+		if resp.StatusCode != testCase.status {
+			t.Errorf("Test %d: expected status %d, got %d", i+1, testCase.status, resp.StatusCode)
+		}
+	}
+
+	// Without a token the admin API is not registered at all.
+	_, disabled := newAdminTestServer(t, "")
+	defer disabled.Close()
+	resp := adminRequest(t, "GET", disabled.URL+"/admin/v1/clients", testAdminToken)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected disabled admin API, got status %d", resp.StatusCode)
+	}
+}
+
+func TestAdminClients(t *testing.T) {
+	xray, srv := newAdminTestServer(t, testAdminToken)
+	defer srv.Close()
+
+	clientID := "admin-test-cam"
+	defer resetRecordForClient(clientID)
+	clnt, _ := connectAdminTestClient(t, xray, srv, clientID)
+	defer clnt.Close()
+	getRecordForClient(clientID, classFace).AppendObjects([]image.Rectangle{image.Rect(0, 0, 10, 10)}, image.Rect(0, 0, 100, 100))
+
+	resp := adminRequest(t, "GET", srv.URL+"/admin/v1/clients", testAdminToken)
+	var sessions []sessionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&sessions); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(sessions) != 1 || sessions[0].ClientID != clientID {
+		t.Fatalf("Expected the session of %s, got %+v", clientID, sessions)
+	}
+
+	resp = adminRequest(t, "GET", srv.URL+"/admin/v1/clients/"+clientID, testAdminToken)
+	var info adminClientInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(info.Sessions) != 1 {
+		t.Errorf("Expected a single session, got %+v", info.Sessions)
+	}
+	if _, ok := info.MotionHistory[classFace]; !ok {
+		t.Errorf("Expected the face motion history, got %+v", info.MotionHistory)
+	}
+
+	// Unknown clients are not found and leave no state behind.
+	resp = adminRequest(t, "GET", srv.URL+"/admin/v1/clients/unknown-cam", testAdminToken)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected unknown client to be not found, got %d", resp.StatusCode)
+	}
+	if classes := getRecordedClassesForClient("unknown-cam"); len(classes) != 0 {
+		t.Errorf("Expected no motion recorded for an unknown client, got %v", classes)
+	}
+	if _, ok := lookupDetectionSchedulerForClient("unknown-cam"); ok {
+		t.Error("Expected no detection scheduler for an unknown client")
+	}
+
+	// Reset clears the motion history.
+	resp = adminRequest(t, "POST", srv.URL+"/admin/v1/clients/"+clientID+"/reset", testAdminToken)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected client to be reset, got %d", resp.StatusCode)
+	}
+	if classes := getRecordedClassesForClient(clientID); len(classes) != 0 {
+		t.Errorf("Expected motion history to be cleared, got %v", classes)
+	}
+
+	// Disconnect closes the connection of the client.
+	resp = adminRequest(t, "POST", srv.URL+"/admin/v1/clients/"+clientID+"/disconnect", testAdminToken)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected client to be disconnected, got %d", resp.StatusCode)
+	}
+	clnt.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := clnt.ReadMessage(); err == nil {
+		t.Error("Expected the connection to be closed")
+	}
+	resp = adminRequest(t, "POST", srv.URL+"/admin/v1/clients/"+clientID+"/disconnect", testAdminToken)
+	resp.Body.Close()
+	for i := 0; resp.StatusCode != http.StatusNotFound && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		resp = adminRequest(t, "POST", srv.URL+"/admin/v1/clients/"+clientID+"/disconnect", testAdminToken)
+		resp.Body.Close()
+	}
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected disconnected client to be gone, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"sync"
+	"time"
+)
+
+// Repeated scans of the same barcode within this window are
+// treated as a single scan.
+const barcodeDedupWindow = 30 * time.Second
+
+// Returns the key identifying a barcode for deduplication, barcodes
+// from clients which do not decode them are identified by their id.
+func barcodeKey(barcode barcodeStruct) string {
+	if barcode.Value == "" {
+		return "id:" + barcode.ID
+	}
+	return barcode.Format + ":" + barcode.Value
+}
+
+// barcodeDeduper remembers the barcodes recently seen by a client.
+type barcodeDeduper struct {
+	mutex    sync.Mutex
+	lastSeen map[string]time.Time
+}
+
+func newBarcodeDeduper() *barcodeDeduper {
+	return &barcodeDeduper{lastSeen: make(map[string]time.Time)}
+}
+
+// FirstSightings returns the barcodes not seen within the dedup
+// window, every barcode passed in extends its own window.
+func (bd *barcodeDeduper) FirstSightings(barcodes []barcodeStruct, now time.Time) []barcodeStruct {
+	bd.mutex.Lock()
+	defer bd.mutex.Unlock()
+
+	// Forget barcodes not seen within the window.
+	for key, seen := range bd.lastSeen {
+		if now.Sub(seen) >= barcodeDedupWindow {
+			delete(bd.lastSeen, key)
+		}
+	}
+
+	var first []barcodeStruct
+	for _, barcode := range barcodes {
+		key := barcodeKey(barcode)
+		if _, ok := bd.lastSeen[key]; !ok {
+			first = append(first, barcode)
+		}
+		bd.lastSeen[key] = now
+	}
+	return first
+}
+
+var (
+	barcodeDeduperMu  sync.Mutex
+	barcodeDeduperMap = make(map[string]*barcodeDeduper)
+)
+
+func getBarcodeDeduperForClient(clientID string) *barcodeDeduper {
+	barcodeDeduperMu.Lock()
+	defer barcodeDeduperMu.Unlock()
+	if _, ok := barcodeDeduperMap[clientID]; !ok {
+		barcodeDeduperMap[clientID] = newBarcodeDeduper()
+	}
+	return barcodeDeduperMap[clientID]
+}
+
+// Drops the recently seen barcodes of a client.
+func resetBarcodeDeduperForClient(clientID string) {
+	barcodeDeduperMu.Lock()
+	delete(barcodeDeduperMap, clientID)
+	barcodeDeduperMu.Unlock()
+}
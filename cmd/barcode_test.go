@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBarcodeDedup(t *testing.T) {
+	bd := newBarcodeDeduper()
+	qr := barcodeStruct{ID: "1", Value: "https://minio.io", Format: "QR_CODE"}
+	ean := barcodeStruct{ID: "2", Value: "4006381333931", Format: "EAN_13"}
+
+	now := time.Now()
+	if first := bd.FirstSightings([]barcodeStruct{qr}, now); len(first) != 1 {
+		t.Fatalf("Expected first sighting of %v", qr)
+	}
+
+	// Repeated scans within the window are dropped, new codes are not.
+	now = now.Add(barcodeDedupWindow / 2)
+	first := bd.FirstSightings([]barcodeStruct{qr, ean}, now)
+	if len(first) != 1 || first[0] != ean {
+		t.Fatalf("Expected only %v, got %v", ean, first)
+	}
+
+	// The window is extended while the code stays visible.
+	now = now.Add(barcodeDedupWindow - time.Second)
+	if first = bd.FirstSightings([]barcodeStruct{qr}, now); len(first) != 0 {
+		t.Fatalf("Expected %v to be deduplicated, got %v", qr, first)
+	}
+
+	now = now.Add(barcodeDedupWindow)
+	if first = bd.FirstSightings([]barcodeStruct{qr, ean}, now); len(first) != 2 {
+		t.Fatalf("Expected both barcodes after the window, got %v", first)
+	}
+}
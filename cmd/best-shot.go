@@ -0,0 +1,288 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"image"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	gocv "github.com/minio/go-cv"
+)
+
+// Frames scored within this window are candidates for the best
+// shot when motion is detected.
+const bestShotWindow = 3 * time.Second
+
+// Maximum number of scored frames kept per client.
+const maxBestShotFrames = 90
+
+// Head rotation in degrees beyond which the pose scores zero.
+const maxBestShotEuler = 45.0
+
+// Face size, as the square root of the fraction of the frame
+// covered, at which the size scores one.
+const fullBestShotSize = 0.5
+
+// Score used for attributes not reported by the client.
+const unknownBestShotScore = 0.5
+
+// Side in pixels at which face crops are measured for sharpness, so
+// that faces of any size are compared at the same scale.
+const sharpnessFaceSize = 64
+
+// Mean absolute gradient of a face crop at which the sharpness
+// scores 0.5.
+const halfSharpnessGradient = 100.0
+
+// Weights of the individual attributes in the face score.
+var bestShotWeights = struct {
+	Pose, Eyes, Smiling, Size, Sharpness float64
+}{
+	Pose:      0.3,
+	Eyes:      0.25,
+	Smiling:   0.1,
+	Size:      0.2,
+	Sharpness: 0.15,
+}
+
+// XrayBestShot - represents the best frame to upload within the
+// recent frames, clients keep a short history of frames and upload
+// the best shot instead of the current frame.
+type XrayBestShot struct {
+	// Frame id of the best frame.
+	FrameID int `json:"FrameId"`
+
+	// Score of the best frame between 0 and 1.
+	Score float64
+
+	// Best frame of every tracked face.
+	Faces []XrayFaceShot `json:",omitempty"`
+}
+
+// XrayFaceShot - represents the best frame of a single face.
+type XrayFaceShot struct {
+	FaceID  string `json:"FaceId"`
+	FrameID int    `json:"FrameId"`
+	Score   float64
+}
+
+// Parses a probability reported by the client, clients report -1
+// for attributes they could not classify.
+func parseProbability(s string) (float64, bool) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil || p < 0 || p > 1 {
+		return 0, false
+	}
+	return p, true
+}
+
+func clamp01(f float64) float64 {
+	return math.Max(0, math.Min(1, f))
+}
+
+// Returns the smiling probability, accepting both the correct
+// and the historical misspelled field.
+func (face faceStruct) smilingProbability() (float64, bool) {
+	if p, ok := parseProbability(face.SmilingProbability); ok {
+		return p, true
+	}
+	return parseProbability(face.Smiling)
+}
+
+// Rates a face between 0 and 1, frontal faces with open eyes and
+// a smile, covering a larger part of a sharp frame score higher.
+func scoreFace(face faceStruct, rect, frame image.Rectangle, sharpness float64) float64 {
+	pose := unknownBestShotScore
+	eulerY, errY := strconv.ParseFloat(face.EulerY, 64)
+	eulerZ, errZ := strconv.ParseFloat(face.EulerZ, 64)
+	if errY == nil && errZ == nil {
+		pose = clamp01(1 - (math.Abs(eulerY)+math.Abs(eulerZ))/(2*maxBestShotEuler))
+	}
+
+	eyes := unknownBestShotScore
+	left, okLeft := parseProbability(face.LeftEyeOpen)
+	right, okRight := parseProbability(face.RightEyeOpen)
+	switch {
+	case okLeft && okRight:
+		eyes = (left + right) / 2
+	case okLeft:
+		eyes = left
+	case okRight:
+		eyes = right
+	}
+
+	smiling, ok := face.smilingProbability()
+	if !ok {
+		smiling = unknownBestShotScore
+	}
+
+	size := 0.0
+	if area := frame.Dx() * frame.Dy(); area > 0 {
+		covered := float64(rect.Dx()*rect.Dy()) / float64(area)
+		size = clamp01(math.Sqrt(covered) / fullBestShotSize)
+	}
+
+	w := bestShotWeights
+	return (w.Pose*pose + w.Eyes*eyes + w.Smiling*smiling + w.Size*size + w.Sharpness*sharpness) /
+		(w.Pose + w.Eyes + w.Smiling + w.Size + w.Sharpness)
+}
+
+// Measures the sharpness of a face crop between 0 and 1 from its
+// mean absolute Sobel gradient, blurred faces score lower.
+func faceSharpness(face image.Image) float64 {
+	b := face.Bounds()
+	src := gocv.NewView(b.Dx(), b.Dy(), gocv.GRAY8)
+	defer src.Close()
+	src.CopyFrom(face)
+	dst := gocv.NewView(sharpnessFaceSize, sharpnessFaceSize, gocv.GRAY8)
+	defer dst.Close()
+	gocv.ResizeBilinear(*src, *dst)
+
+	sum := gocv.SobelDxAbsSum(*dst) + gocv.SobelDyAbsSum(*dst)
+	gradient := float64(sum) / (sharpnessFaceSize * sharpnessFaceSize)
+	return gradient / (gradient + halfSharpnessGradient)
+}
+
+// Returns the sharpness of the frame reported by the client.
+func (fr *frameRecord) GetSharpness() float64 {
+	if s, ok := parseProbability(fr.Frame.Sharpness); ok {
+		return s
+	}
+	return unknownBestShotScore
+}
+
+// scoredFrame is a frame with the scores of its faces.
+type scoredFrame struct {
+	frameID int
+	time    time.Time
+	score   float64
+	faces   map[string]float64
+}
+
+// bestShotScorer keeps the scores of the recent frames of a client.
+type bestShotScorer struct {
+	mutex  sync.Mutex
+	frames []scoredFrame
+}
+
+// Add scores the faces of a frame, frames without faces are ignored.
+// The sharpness of the faces is measured on the binary frame if any,
+// otherwise the sharpness reported by the client is used.
+func (bs *bestShotScorer) Add(fr *frameRecord, frameID int, frame image.Rectangle, binary *frameImage, now time.Time) {
+	if len(fr.Faces) == 0 {
+		return
+	}
+	rects, err := fr.GetFaceRectangles()
+	if err != nil {
+		return
+	}
+
+	// Frames which cannot be decoded fall back to the client.
+	img, _ := binary.Decode()
+	si, _ := img.(subImager)
+
+	sf := scoredFrame{frameID: frameID, time: now, faces: make(map[string]float64)}
+	for i, face := range fr.Faces {
+		sharpness := fr.GetSharpness()
+		if si != nil {
+			if r := scaleRect(rects[i], frame, img.Bounds()); !r.Empty() {
+				sharpness = faceSharpness(si.SubImage(r))
+			}
+		}
+		score := scoreFace(face, rects[i], frame, sharpness)
+		sf.faces[face.ID] = score
+		sf.score += score
+	}
+	sf.score /= float64(len(fr.Faces))
+
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	bs.frames = append(bs.frames, sf)
+	if len(bs.frames) > maxBestShotFrames {
+		bs.frames = bs.frames[1:]
+	}
+}
+
+// Best returns the best frame within the window, nil if no frame
+// with faces was scored.
+func (bs *bestShotScorer) Best(now time.Time) *XrayBestShot {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+
+	// Forget frames older than the window.
+	i := 0
+	for ; i < len(bs.frames); i++ {
+		if now.Sub(bs.frames[i].time) < bestShotWindow {
+			break
+		}
+	}
+	bs.frames = bs.frames[i:]
+	if len(bs.frames) == 0 {
+		return nil
+	}
+
+	best := &XrayBestShot{FrameID: -1}
+	faceShots := make(map[string]*XrayFaceShot)
+	var faceIDs []string
+	for _, sf := range bs.frames {
+		if best.FrameID == -1 || sf.score > best.Score {
+			best.FrameID, best.Score = sf.frameID, sf.score
+		}
+		for id, score := range sf.faces {
+			shot, ok := faceShots[id]
+			if !ok {
+				shot = &XrayFaceShot{FaceID: id, FrameID: sf.frameID, Score: score}
+				faceShots[id] = shot
+				faceIDs = append(faceIDs, id)
+			}
+			if score > shot.Score {
+				shot.FrameID, shot.Score = sf.frameID, score
+			}
+		}
+	}
+	for _, id := range faceIDs {
+		best.Faces = append(best.Faces, *faceShots[id])
+	}
+	return best
+}
+
+var (
+	bestShotMu  sync.Mutex
+	bestShotMap = make(map[string]*bestShotScorer)
+)
+
+func getBestShotScorerForClient(clientID string) *bestShotScorer {
+	bestShotMu.Lock()
+	defer bestShotMu.Unlock()
+	if _, ok := bestShotMap[clientID]; !ok {
+		bestShotMap[clientID] = &bestShotScorer{}
+	}
+	return bestShotMap[clientID]
+}
+
+// Drops the scored frames of a client.
+func resetBestShotScorerForClient(clientID string) {
+	bestShotMu.Lock()
+	delete(bestShotMap, clientID)
+	bestShotMu.Unlock()
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	gocv "github.com/minio/go-cv"
+)
+
+func TestScoreFace(t *testing.T) {
+	frame := image.Rect(0, 0, 640, 480)
+	rect := image.Rect(200, 100, 400, 300)
+	good := faceStruct{EulerY: "2.0", EulerZ: "-1.0", LeftEyeOpen: "0.95", RightEyeOpen: "0.9", SmilingProbability: "0.8"}
+	blink := faceStruct{EulerY: "2.0", EulerZ: "-1.0", LeftEyeOpen: "0.05", RightEyeOpen: "0.1", SmilingProbability: "0.8"}
+	turned := faceStruct{EulerY: "40.0", EulerZ: "15.0", LeftEyeOpen: "0.95", RightEyeOpen: "0.9", Smiling: "0.8"}
+
+	goodScore := scoreFace(good, rect, frame, 0.5)
+	if s := scoreFace(blink, rect, frame, 0.5); s >= goodScore {
+		t.Errorf("Expected closed eyes to score lower, got %f >= %f", s, goodScore)
+	}
+	if s := scoreFace(turned, rect, frame, 0.5); s >= goodScore {
+		t.Errorf("Expected turned head to score lower, got %f >= %f", s, goodScore)
+	}
+	if s := scoreFace(good, image.Rect(300, 200, 320, 220), frame, 0.5); s >= goodScore {
+		t.Errorf("Expected smaller face to score lower, got %f >= %f", s, goodScore)
+	}
+	if s := scoreFace(good, rect, frame, 0.9); s <= goodScore {
+		t.Errorf("Expected sharper frame to score higher, got %f <= %f", s, goodScore)
+	}
+}
+
+func TestBestShot(t *testing.T) {
+	frame := image.Rect(0, 0, 640, 480)
+	newFrame := func(leftEye, rightEye string) *frameRecord {
+		return &frameRecord{Faces: []faceStruct{{
+			ID: "1", EulerY: "0", EulerZ: "0", LeftEyeOpen: leftEye, RightEyeOpen: rightEye,
+			FacePT1: pointStruct{X: "200", Y: "100"}, FacePT2: pointStruct{X: "400", Y: "300"},
+		}}}
+	}
+
+	bs := &bestShotScorer{}
+	now := time.Now()
+	if best := bs.Best(now); best != nil {
+		t.Fatalf("Expected no best shot, got %v", best)
+	}
+
+	bs.Add(newFrame("0.9", "0.9"), 1, frame, nil, now)
+	bs.Add(newFrame("0.1", "0.2"), 2, frame, nil, now.Add(100*time.Millisecond))
+	bs.Add(&frameRecord{}, 3, frame, nil, now.Add(200*time.Millisecond))
+
+	best := bs.Best(now.Add(time.Second))
+	if best == nil || best.FrameID != 1 || len(best.Faces) != 1 || best.Faces[0].FrameID != 1 {
+		t.Fatalf("Expected frame 1 as best shot, got %v", best)
+	}
+
+	// Frames older than the window are forgotten.
+	if best = bs.Best(now.Add(bestShotWindow + time.Second)); best != nil {
+		t.Fatalf("Expected no best shot after the window, got %v", best)
+	}
+}
+
+// Returns lena and a copy blurred by scaling it down and up again.
+func sharpAndBlurredLena(t *testing.T) (image.Image, image.Image) {
+	lena := &gocv.View{}
+	if err := lena.Load("../contrib/Simd/data/image/face/lena.pgm"); err != nil {
+		t.Fatal(err)
+	}
+	defer lena.Close()
+	small := gocv.NewView(lena.Width()/8, lena.Height()/8, gocv.GRAY8)
+	defer small.Close()
+	blurred := gocv.NewView(lena.Width(), lena.Height(), gocv.GRAY8)
+	defer blurred.Close()
+	gocv.ResizeBilinear(*lena, *small)
+	gocv.ResizeBilinear(*small, *blurred)
+
+	sharpImg, err := lena.Image()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blurredImg, err := blurred.Image()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sharpImg, blurredImg
+}
+
+func TestFaceSharpness(t *testing.T) {
+	sharp, blurred := sharpAndBlurredLena(t)
+	face := image.Rect(100, 90, 210, 200)
+
+	s := faceSharpness(sharp.(subImager).SubImage(face))
+	b := faceSharpness(blurred.(subImager).SubImage(face))
+	if s <= b || s <= 0 || s >= 1 {
+		t.Errorf("Expected sharp face to score above the blurred one within (0, 1), got %v and %v", s, b)
+	}
+	if f := faceSharpness(image.NewGray(face)); f != 0 {
+		t.Errorf("Expected flat face to score 0, got %v", f)
+	}
+
+	// The sharpness measured on binary frames picks the best shot,
+	// whatever the client reports.
+	binary := func(img image.Image) *frameImage {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+			t.Fatal(err)
+		}
+		return &frameImage{data: buf.Bytes()}
+	}
+	fr := &frameRecord{
+		Frame: frameStruct{Sharpness: "0.9"},
+		Faces: []faceStruct{{ID: "1", FacePT1: pointStruct{X: "100", Y: "90"}, FacePT2: pointStruct{X: "210", Y: "200"}}},
+	}
+	frame := sharp.Bounds()
+	now := time.Now()
+	bs := &bestShotScorer{}
+	bs.Add(fr, 1, frame, binary(blurred), now)
+	bs.Add(fr, 2, frame, binary(sharp), now)
+	if best := bs.Best(now); best == nil || best.FrameID != 2 {
+		t.Errorf("Expected the sharp frame as best shot, got %v", best)
+	}
+}
@@ -0,0 +1,386 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"strings"
+	"sync"
+	"time"
+)
+
+// burstOptions configures the frames captured around a trigger.
+type burstOptions struct {
+	// Number of frames before and after the trigger.
+	Pre, Post int
+
+	// Time to wait for the frames after the trigger.
+	PostTimeout time.Duration
+}
+
+// Returns the burst options configured through the environment,
+// bursts are disabled when both frame counts are zero.
+func getBurstOptions() burstOptions {
+	return burstOptions{
+		Pre:         envInt("BURST_PRE_FRAMES", 5),
+		Post:        envInt("BURST_POST_FRAMES", 5),
+		PostTimeout: 10 * time.Second,
+	}
+}
+
+// Burst frames are uploaded beside the trigger snapshot, under a
+// prefix named after it.
+const burstManifestName = "manifest.json"
+
+// Returns the object prefix of the burst of a trigger snapshot.
+func burstPrefix(objectName string) string {
+	return strings.TrimSuffix(objectName, ".jpg") + "/"
+}
+
+// Returns the object name of a frame at offset from the trigger,
+// negative offsets are frames before the trigger.
+func burstFrameName(prefix string, offset int) string {
+	if offset < 0 {
+		return fmt.Sprintf("%spre-%02d", prefix, -offset)
+	}
+	return fmt.Sprintf("%spost-%02d", prefix, offset)
+}
+
+// XrayBurst - represents the frames requested around a trigger,
+// clients keeping a history of frames upload them to the presigned
+// URLs, binary frames are captured by the server instead.
+type XrayBurst struct {
+	// Object name of the burst manifest.
+	Manifest string
+
+	// Number of frames before and after the trigger.
+	Pre, Post int
+
+	// Presigned URLs for the frames in order, the pre frames
+	// oldest first followed by the post frames. Empty when the
+	// server captures the frames.
+	URLs []string `json:",omitempty"`
+}
+
+// burstManifest lists the frames of a burst.
+type burstManifest struct {
+	ClientID string       `json:"clientId"`
+	FrameID  int          `json:"frameId"`
+	Time     time.Time    `json:"time"`
+	Trigger  string       `json:"trigger"`          // Object name of the trigger snapshot.
+	Source   string       `json:"source"`           // Either client or server.
+	Frames   []burstFrame `json:"frames"`           // In capture order.
+	Events   []XrayEvent  `json:"events,omitempty"` // Events of the trigger frame.
+}
+
+// burstFrame is a single frame of a burst manifest.
+type burstFrame struct {
+	ObjectName string     `json:"objectName"`
+	Offset     int        `json:"offset"`
+	Time       *time.Time `json:"time,omitempty"` // Receive time, server captures only.
+}
+
+// Sources of burst frames.
+const (
+	burstSourceClient = "client"
+	burstSourceServer = "server"
+)
+
+// bufferedFrame is a binary frame received from a client.
+type bufferedFrame struct {
+	data []byte
+	time time.Time
+}
+
+// frameImage is the binary frame of a frame record, decoded once on
+// first use by the server side analyses of the frame.
+type frameImage struct {
+	data    []byte
+	img     image.Image
+	err     error
+	decoded bool
+}
+
+// Decode returns the decoded frame, nil if no binary frame was
+// received with the frame record.
+func (f *frameImage) Decode() (image.Image, error) {
+	if f == nil {
+		return nil, nil
+	}
+	if !f.decoded {
+		f.img, _, f.err = image.Decode(bytes.NewReader(f.data))
+		f.decoded = true
+	}
+	return f.img, f.err
+}
+
+// burstCapture collects the frames following a trigger.
+type burstCapture struct {
+	pre   []bufferedFrame
+	post  []bufferedFrame
+	want  int
+	start time.Time
+	done  func(pre, post []bufferedFrame)
+
+	// Expires the burst when the client stops sending frames.
+	timer *time.Timer
+}
+
+// Stops the expiry timer and finishes the burst.
+func (c *burstCapture) finish() {
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.done(c.pre, c.post)
+}
+
+// frameBuffer keeps the recent binary frames of a connection and
+// the bursts waiting for frames after their trigger.
+type frameBuffer struct {
+	mutex       sync.Mutex
+	pre         int
+	postTimeout time.Duration
+	frames      []bufferedFrame
+	captures    []*burstCapture
+}
+
+func newFrameBuffer(opts burstOptions) *frameBuffer {
+	return &frameBuffer{pre: opts.Pre, postTimeout: opts.PostTimeout}
+}
+
+// Len returns the number of buffered frames.
+func (fb *frameBuffer) Len() int {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	return len(fb.frames)
+}
+
+// Binary frames received further apart from a frame record than
+// this are not considered to be the same frame.
+const maxFrameAge = 500 * time.Millisecond
+
+// Match returns the frame received closest to the frame record
+// received at t, if any was received within maxAge.
+func (fb *frameBuffer) Match(t time.Time, maxAge time.Duration) (bufferedFrame, bool) {
+	fb.mutex.Lock()
+	defer fb.mutex.Unlock()
+	var match bufferedFrame
+	found := false
+	best := maxAge
+	for _, frame := range fb.frames {
+		d := frame.time.Sub(t)
+		if d < 0 {
+			d = -d
+		}
+		if d <= best {
+			match, found, best = frame, true, d
+		}
+	}
+	return match, found
+}
+
+// Add buffers a frame and hands it to the pending bursts, completed
+// and expired bursts are finished.
+func (fb *frameBuffer) Add(data []byte, now time.Time) {
+	frame := bufferedFrame{data: data, time: now}
+
+	fb.mutex.Lock()
+	// Keep at least the last frame, to know the client sends
+	// binary frames.
+	fb.frames = append(fb.frames, frame)
+	if size := max(fb.pre, 1); len(fb.frames) > size {
+		fb.frames = fb.frames[len(fb.frames)-size:]
+	}
+
+	var finished, pending []*burstCapture
+	for _, c := range fb.captures {
+		c.post = append(c.post, frame)
+		if len(c.post) >= c.want || now.Sub(c.start) >= fb.postTimeout {
+			finished = append(finished, c)
+		} else {
+			pending = append(pending, c)
+		}
+	}
+	fb.captures = pending
+	fb.mutex.Unlock()
+
+	for _, c := range finished {
+		c.finish()
+	}
+}
+
+// Finishes a pending burst whose post timeout elapsed before all
+// its frames were received.
+func (fb *frameBuffer) expire(c *burstCapture) {
+	fb.mutex.Lock()
+	found := false
+	for i := range fb.captures {
+		if fb.captures[i] == c {
+			fb.captures = append(fb.captures[:i], fb.captures[i+1:]...)
+			found = true
+			break
+		}
+	}
+	fb.mutex.Unlock()
+
+	if found {
+		c.done(c.pre, c.post)
+	}
+}
+
+// Capture starts a burst around the trigger frame received at
+// trigger, done is called once post frames were received, the post
+// timeout elapsed or the connection is closed.
+func (fb *frameBuffer) Capture(post int, trigger time.Time, done func(pre, post []bufferedFrame)) {
+	fb.mutex.Lock()
+	c := &burstCapture{
+		want:  post,
+		start: trigger,
+		done:  done,
+	}
+	// The trigger frame is uploaded as the snapshot, frames buffered
+	// while it was analyzed follow it.
+	for _, frame := range fb.frames {
+		switch {
+		case frame.time.Before(trigger):
+			c.pre = append(c.pre, frame)
+		case frame.time.After(trigger) && len(c.post) < post:
+			c.post = append(c.post, frame)
+		}
+	}
+	c.pre = c.pre[len(c.pre)-min(fb.pre, len(c.pre)):]
+	pending := len(c.post) < post
+	if pending {
+		fb.captures = append(fb.captures, c)
+		c.timer = time.AfterFunc(fb.postTimeout, func() { fb.expire(c) })
+	}
+	fb.mutex.Unlock()
+
+	if !pending {
+		done(c.pre, c.post)
+	}
+}
+
+// Close finishes all the pending bursts with the frames received
+// so far.
+func (fb *frameBuffer) Close() {
+	fb.mutex.Lock()
+	captures := fb.captures
+	fb.captures = nil
+	fb.frames = nil
+	fb.mutex.Unlock()
+
+	for _, c := range captures {
+		c.finish()
+	}
+}
+
+// Starts a burst for a trigger snapshot received at trigger. Frames
+// of clients sending binary frames are captured by the server, other
+// clients are asked to upload their frames to presigned URLs.
+func (v *xrayHandlers) startBurst(wc *wConn, manifest burstManifest, trigger time.Time) (*XrayBurst, error) {
+	opts := getBurstOptions()
+	if opts.Pre <= 0 && opts.Post <= 0 {
+		return nil, nil
+	}
+
+	// The manifest is uploaded asynchronously, keep the events of
+	// the caller free to be updated.
+	manifest.Events = append([]XrayEvent(nil), manifest.Events...)
+
+	prefix := burstPrefix(manifest.Trigger)
+	burst := &XrayBurst{
+		Manifest: prefix + burstManifestName,
+		Pre:      opts.Pre,
+		Post:     opts.Post,
+	}
+
+	if wc.frames.Len() > 0 {
+		manifest.Source = burstSourceServer
+		v.uploadWG.Add(1)
+		wc.frames.Capture(opts.Post, trigger, func(pre, post []bufferedFrame) {
+			go func() {
+				defer v.uploadWG.Done()
+				v.uploadBurst(wc, prefix, manifest, pre, post)
+			}()
+		})
+		return burst, nil
+	}
+
+	manifest.Source = burstSourceClient
+	for offset := -opts.Pre; offset <= opts.Post; offset++ {
+		if offset == 0 {
+			continue
+		}
+		objectName := burstFrameName(prefix, offset)
+		pp, err := v.newPresignedURL(objectName)
+		if err != nil {
+			return nil, err
+		}
+		burst.URLs = append(burst.URLs, pp.String())
+		manifest.Frames = append(manifest.Frames, burstFrame{ObjectName: objectName, Offset: offset})
+	}
+
+	v.uploadWG.Add(1)
+	go func() {
+		defer v.uploadWG.Done()
+		entryErrorIf(wc.log, v.putManifest(burst.Manifest, manifest), "Unable to upload burst manifest")
+	}()
+	return burst, nil
+}
+
+// Uploads the frames captured by the server followed by the manifest.
+func (v *xrayHandlers) uploadBurst(wc *wConn, prefix string, manifest burstManifest, pre, post []bufferedFrame) {
+	upload := func(frame bufferedFrame, offset int) {
+		objectName := burstFrameName(prefix, offset)
+		data, contentType, err := encodeFrame(frame.data)
+		if err == nil {
+			_, err = v.minioClient.PutObject(globalMinioClntConfig.BucketName(), objectName,
+				bytes.NewReader(data), contentType)
+		}
+		if err != nil {
+			entryErrorIf(wc.log, err, "Unable to upload burst frame %s", objectName)
+			return
+		}
+		t := frame.time.UTC()
+		manifest.Frames = append(manifest.Frames, burstFrame{ObjectName: objectName, Offset: offset, Time: &t})
+	}
+	for i, frame := range pre {
+		upload(frame, i-len(pre))
+	}
+	for i, frame := range post {
+		upload(frame, i+1)
+	}
+	entryErrorIf(wc.log, v.putManifest(prefix+burstManifestName, manifest), "Unable to upload burst manifest")
+}
+
+// Uploads a burst manifest.
+func (v *xrayHandlers) putManifest(objectName string, manifest burstManifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	_, err = v.minioClient.PutObject(globalMinioClntConfig.BucketName(), objectName,
+		bytes.NewReader(data), "application/json")
+	return err
+}
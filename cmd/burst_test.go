@@ -0,0 +1,267 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	minio "github.com/minio/minio-go"
+)
+
+func TestFrameBufferCapture(t *testing.T) {
+	fb := newFrameBuffer(burstOptions{Pre: 2, Post: 2, PostTimeout: 10 * time.Second})
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		fb.Add([]byte{byte(i)}, now.Add(time.Duration(i-4)*time.Millisecond))
+	}
+	if fb.Len() != 2 {
+		t.Fatalf("Expected 2 buffered frames, got %d", fb.Len())
+	}
+
+	var pre, post []bufferedFrame
+	done := 0
+	fb.Capture(2, now, func(p, q []bufferedFrame) {
+		pre, post = p, q
+		done++
+	})
+	fb.Add([]byte{4}, now.Add(time.Millisecond))
+	if done != 0 {
+		t.Fatal("Expected burst to wait for post frames")
+	}
+	fb.Add([]byte{5}, now.Add(2*time.Millisecond))
+	fb.Add([]byte{6}, now.Add(3*time.Millisecond))
+	if done != 1 {
+		t.Fatalf("Expected burst to complete once, got %d", done)
+	}
+	if len(pre) != 2 || pre[0].data[0] != 2 || pre[1].data[0] != 3 {
+		t.Errorf("Unexpected pre frames %v", pre)
+	}
+	if len(post) != 2 || post[0].data[0] != 4 || post[1].data[0] != 5 {
+		t.Errorf("Unexpected post frames %v", post)
+	}
+
+	// Closing finishes pending bursts with the frames received.
+	fb.Capture(2, now.Add(4*time.Millisecond), func(p, q []bufferedFrame) {
+		pre, post = p, q
+		done++
+	})
+	fb.Close()
+	if done != 2 || len(post) != 0 {
+		t.Errorf("Expected burst to complete on close, got %d with %v", done, post)
+	}
+}
+
+func TestFrameBufferCaptureTrigger(t *testing.T) {
+	fb := newFrameBuffer(burstOptions{Pre: 4, Post: 2, PostTimeout: 10 * time.Second})
+	trigger := time.Now()
+	for i := 0; i < 4; i++ {
+		fb.Add([]byte{byte(i)}, trigger.Add(time.Duration(i-2)*time.Millisecond))
+	}
+
+	// The trigger frame is in neither window, frames received while
+	// the trigger was analyzed follow it.
+	var pre, post []bufferedFrame
+	done := 0
+	fb.Capture(2, trigger, func(p, q []bufferedFrame) {
+		pre, post = p, q
+		done++
+	})
+	fb.Add([]byte{4}, trigger.Add(2*time.Millisecond))
+	if done != 1 {
+		t.Fatalf("Expected burst to complete once, got %d", done)
+	}
+	if len(pre) != 2 || pre[0].data[0] != 0 || pre[1].data[0] != 1 {
+		t.Errorf("Unexpected pre frames %v", pre)
+	}
+	if len(post) != 2 || post[0].data[0] != 3 || post[1].data[0] != 4 {
+		t.Errorf("Unexpected post frames %v", post)
+	}
+
+	// Bursts whose post frames are buffered already complete at once.
+	fb.Capture(1, trigger, func(p, q []bufferedFrame) {
+		pre, post = p, q
+		done++
+	})
+	if done != 2 || len(post) != 1 || post[0].data[0] != 3 {
+		t.Errorf("Expected burst to complete with the buffered frame, got %d with %v", done, post)
+	}
+}
+
+func TestFrameBufferMatch(t *testing.T) {
+	fb := newFrameBuffer(burstOptions{Pre: 3})
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		fb.Add([]byte{byte(i)}, now.Add(time.Duration(i)*100*time.Millisecond))
+	}
+
+	testCases := []struct {
+		received time.Time
+		found    bool
+		expected byte
+	}{
+		{now, true, 0},
+		{now.Add(140 * time.Millisecond), true, 1},
+		{now.Add(160 * time.Millisecond), true, 2},
+		// Frames received after the frame record match too.
+		{now.Add(-100 * time.Millisecond), true, 0},
+		// Frames too far apart from the frame record are absent.
+		{now.Add(time.Second), false, 0},
+		{now.Add(-time.Second), false, 0},
+	}
+	for i, testCase := range testCases {
+		frame, found := fb.Match(testCase.received, maxFrameAge)
+		if found != testCase.found {
+			t.Errorf("Test %d: expected found %v, got %v", i+1, testCase.found, found)
+			continue
+		}
+		if found && frame.data[0] != testCase.expected {
+			t.Errorf("Test %d: expected frame %d, got %d", i+1, testCase.expected, frame.data[0])
+		}
+	}
+}
+
+func TestFrameImageDecode(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 32, 24)), nil); err != nil {
+		t.Fatal(err)
+	}
+	binary := &frameImage{data: buf.Bytes()}
+	img, err := binary.Decode()
+	if err != nil || img.Bounds() != image.Rect(0, 0, 32, 24) {
+		t.Fatalf("Unexpected frame %v, %v", img, err)
+	}
+
+	// Frames are decoded once.
+	binary.data = nil
+	if again, err := binary.Decode(); err != nil || again != img {
+		t.Errorf("Expected the decoded frame to be reused, got %v, %v", again, err)
+	}
+
+	// Frame records without a binary frame have no image.
+	if img, err = (*frameImage)(nil).Decode(); img != nil || err != nil {
+		t.Errorf("Expected no frame, got %v, %v", img, err)
+	}
+}
+
+func TestFrameBufferExpire(t *testing.T) {
+	fb := newFrameBuffer(burstOptions{Pre: 1, Post: 2, PostTimeout: 10 * time.Millisecond})
+	fb.Add([]byte{0}, time.Now())
+
+	// A client which stops sending frames does not hold the burst
+	// until it disconnects.
+	finished := make(chan []bufferedFrame, 1)
+	fb.Capture(2, time.Now(), func(pre, post []bufferedFrame) {
+		finished <- post
+	})
+	fb.Add([]byte{1}, time.Now())
+	select {
+	case post := <-finished:
+		if len(post) != 1 || post[0].data[0] != 1 {
+			t.Errorf("Unexpected post frames %v", post)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected burst to expire after the post timeout")
+	}
+
+	// Expired bursts are not finished again on close.
+	fb.Close()
+	select {
+	case <-finished:
+		t.Error("Expected burst to complete once")
+	default:
+	}
+}
+
+func TestStartBurstEvents(t *testing.T) {
+	os.Setenv("BURST_PRE_FRAMES", "2")
+	os.Setenv("BURST_POST_FRAMES", "0")
+	defer os.Unsetenv("BURST_PRE_FRAMES")
+	defer os.Unsetenv("BURST_POST_FRAMES")
+
+	var mu sync.Mutex
+	uploads := make(map[string][]byte)
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		uploads[r.URL.Path] = data
+		mu.Unlock()
+	}))
+	defer storage.Close()
+	clnt, err := minio.NewWithRegion(strings.TrimPrefix(storage.URL, "http://"), "access", "secret", false, "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xray := newXRayHandlers(clnt)
+
+	wc := &wConn{log: logrus.NewEntry(logrus.New()), frames: newFrameBuffer(getBurstOptions())}
+	now := time.Now()
+	wc.frames.Add([]byte("frame"), now.Add(-time.Millisecond))
+
+	// The caller updates its events while the manifest is uploaded,
+	// run with -race to check they are not shared.
+	events := []XrayEvent{{Type: eventMotion, Class: classFace}}
+	burst, err := xray.startBurst(wc, burstManifest{
+		ClientID: "client",
+		Trigger:  "trigger.jpg",
+		Events:   events,
+	}, now)
+	if err != nil || burst == nil {
+		t.Fatalf("Expected burst to start, got %v", err)
+	}
+	for i := range events {
+		events[i].Manifest = burst.Manifest
+	}
+	xray.uploadWG.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	var manifest burstManifest
+	if err = json.Unmarshal(uploads["/"+globalMinioClntConfig.BucketName()+"/"+burst.Manifest], &manifest); err != nil {
+		t.Fatalf("Expected burst manifest to be uploaded, got %v", err)
+	}
+	if manifest.Source != burstSourceServer || len(manifest.Frames) != 1 || len(manifest.Events) != 1 {
+		t.Errorf("Unexpected burst manifest %+v", manifest)
+	}
+}
+
+func TestBurstFrameName(t *testing.T) {
+	prefix := burstPrefix("19-Oct-2026-UTC/06hrs-31mins-00secs.jpg")
+	if prefix != "19-Oct-2026-UTC/06hrs-31mins-00secs/" {
+		t.Fatalf("Unexpected prefix %s", prefix)
+	}
+	if name := burstFrameName(prefix, -3); name != prefix+"pre-03" {
+		t.Errorf("Unexpected pre frame name %s", name)
+	}
+	if name := burstFrameName(prefix, 1); name != prefix+"post-01" {
+		t.Errorf("Unexpected post frame name %s", name)
+	}
+}
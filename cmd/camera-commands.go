@@ -0,0 +1,274 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Camera command types understood by the clients.
+const (
+	cmdSetResolution  = "setResolution"
+	cmdSetFrameRate   = "setFrameRate"
+	cmdSetTorch       = "setTorch"
+	cmdSetFocus       = "setFocus"
+	cmdSetExposure    = "setExposure"
+	cmdStartStreaming = "startStreaming"
+	cmdStopStreaming  = "stopStreaming"
+	cmdSetMode        = "setMode"
+)
+
+// Detection modes for the set mode command.
+const (
+	detectionModeFace    = "face"
+	detectionModeBarcode = "barcode"
+)
+
+// Command acknowledgement states.
+const (
+	cmdStatusPending = "pending"
+	cmdStatusOK      = "ok"
+	cmdStatusError   = "error"
+	cmdStatusTimeout = "timeout"
+	cmdStatusClosed  = "closed"
+)
+
+// Time a client has to acknowledge a command.
+const commandAckTimeout = 10 * time.Second
+
+// Maximum number of completed commands remembered per connection.
+const maxCommandHistory = 32
+
+var (
+	errInvalidCommand  = errors.New("Invalid camera command")
+	errUnknownCommand  = errors.New("Unknown camera command")
+	errCommandsStopped = errors.New("Connection is closed, no more commands accepted")
+)
+
+// validateCommand verifies the parameters required by each command.
+func validateCommand(cmd XrayCommand) error {
+	switch cmd.Command {
+	case cmdSetResolution:
+		if cmd.Width <= 0 || cmd.Height <= 0 {
+			return errInvalidCommand
+		}
+	case cmdSetFrameRate:
+		if cmd.FrameRate <= 0 {
+			return errInvalidCommand
+		}
+	case cmdSetTorch:
+		if cmd.Torch == nil {
+			return errInvalidCommand
+		}
+	case cmdSetFocus:
+		if cmd.FocusPoint == nil {
+			return errInvalidCommand
+		}
+	case cmdSetExposure:
+		if cmd.ExposureRegion == nil || cmd.ExposureRegion.Empty() {
+			return errInvalidCommand
+		}
+	case cmdStartStreaming, cmdStopStreaming:
+	case cmdSetMode:
+		if cmd.Mode != detectionModeFace && cmd.Mode != detectionModeBarcode {
+			return errInvalidCommand
+		}
+	default:
+		return errUnknownCommand
+	}
+	return nil
+}
+
+// commandAck is the acknowledgement sent by a client, the command id
+// is echoed as received in XrayCommand.
+type commandAck struct {
+	ClientID string `json:"client_uuid"`
+	Ack      struct {
+		CommandID uint64 `json:"CommandId"`
+		Status    string `json:"status"`
+		Error     string `json:"error"`
+	} `json:"commandAck"`
+}
+
+// commandStatus is the state of a command sent to a client.
+type commandStatus struct {
+	Command  XrayCommand `json:"command"`
+	Status   string      `json:"status"`
+	Error    string      `json:"error,omitempty"`
+	SentAt   time.Time   `json:"sentAt"`
+	AckedAt  *time.Time  `json:"ackedAt,omitempty"`
+	doneCh   chan struct{}
+	ackTimer *time.Timer
+}
+
+// commandTracker tracks the commands sent on a connection
+// until they are acknowledged or time out.
+type commandTracker struct {
+	mu      sync.Mutex
+	lastID  uint64
+	stopped bool
+	pending map[uint64]*commandStatus
+	history []commandStatus
+}
+
+func newCommandTracker() *commandTracker {
+	return &commandTracker{
+		pending: make(map[uint64]*commandStatus),
+	}
+}
+
+// Registers a new command and assigns it an id, the command
+// times out unless acknowledged within commandAckTimeout.
+func (t *commandTracker) add(cmd XrayCommand) (*commandStatus, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return nil, errCommandsStopped
+	}
+	t.lastID++
+	cmd.CommandID = t.lastID
+	cs := &commandStatus{
+		Command: cmd,
+		Status:  cmdStatusPending,
+		SentAt:  time.Now().UTC(),
+		doneCh:  make(chan struct{}),
+	}
+	cs.ackTimer = time.AfterFunc(commandAckTimeout, func() {
+		t.complete(cmd.CommandID, cmdStatusTimeout, "")
+	})
+	t.pending[cmd.CommandID] = cs
+	return cs, nil
+}
+
+// Completes a pending command, returns false if the command is
+// unknown or has already completed.
+func (t *commandTracker) complete(id uint64, status, errMsg string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.completeLocked(id, status, errMsg)
+}
+
+func (t *commandTracker) completeLocked(id uint64, status, errMsg string) bool {
+	cs, ok := t.pending[id]
+	if !ok {
+		return false
+	}
+	delete(t.pending, id)
+	cs.ackTimer.Stop()
+
+	now := time.Now().UTC()
+	cs.Status = status
+	cs.Error = errMsg
+	if status == cmdStatusOK || status == cmdStatusError {
+		cs.AckedAt = &now
+	}
+	close(cs.doneCh)
+
+	t.history = append(t.history, *cs)
+	if len(t.history) > maxCommandHistory {
+		t.history = t.history[1:]
+	}
+	return true
+}
+
+// Stops accepting commands and fails all the pending ones.
+func (t *commandTracker) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	for id := range t.pending {
+		t.completeLocked(id, cmdStatusClosed, "")
+	}
+}
+
+// Returns the status of a command after it has completed.
+func (t *commandTracker) status(cs *commandStatus) commandStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return *cs
+}
+
+// List returns the pending commands followed by the most recently
+// completed ones.
+func (t *commandTracker) List() []commandStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	list := make([]commandStatus, 0, len(t.pending)+len(t.history))
+	for id := uint64(1); id <= t.lastID; id++ {
+		if cs, ok := t.pending[id]; ok {
+			list = append(list, *cs)
+		}
+	}
+	return append(list, t.history...)
+}
+
+// SendCommand validates and pushes a camera command to the client,
+// the returned status is completed once the client acknowledges the
+// command, the command times out or the connection is closed.
+func (w *wConn) SendCommand(cmd XrayCommand) (*commandStatus, error) {
+	if err := validateCommand(cmd); err != nil {
+		return nil, err
+	}
+	cs, err := w.commands.add(cmd)
+	if err != nil {
+		return nil, err
+	}
+	w.log.WithField("command", cs.Command.Command).Infof("Sending camera command %d", cs.Command.CommandID)
+	if err = w.writeValue(websocket.TextMessage, cs.Command); err != nil {
+		w.commands.complete(cs.Command.CommandID, cmdStatusError, err.Error())
+		return nil, err
+	}
+	return cs, nil
+}
+
+// WaitCommand waits for a command sent with SendCommand to complete.
+func (w *wConn) WaitCommand(cs *commandStatus) commandStatus {
+	<-cs.doneCh
+	return w.commands.status(cs)
+}
+
+// Handles an acknowledgement for a previously sent command.
+func (w *wConn) handleCommandAck(data []byte) {
+	var ack commandAck
+	if err := json.Unmarshal(data, &ack); err != nil {
+		entryErrorIf(w.log, err, "Unable to unmarshal command acknowledgement")
+		return
+	}
+	id := ack.Ack.CommandID
+	status := cmdStatusOK
+	if ack.Ack.Status != cmdStatusOK {
+		status = cmdStatusError
+	}
+	if !w.commands.complete(id, status, ack.Ack.Error) {
+		entryErrorIf(w.log, fmt.Errorf("Command %d is not pending", id),
+			"Unexpected command acknowledgement")
+	}
+}
+
+// Returns true if the incoming message is a command acknowledgement.
+func isCommandAck(keys messageKeys) bool {
+	return keys.has("commandAck")
+}
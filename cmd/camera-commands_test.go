@@ -0,0 +1,127 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCameraCommandAck(t *testing.T) {
+	xray := newXRayHandlers(nil)
+	srv := httptest.NewServer(http.HandlerFunc(xray.Detect))
+	defer srv.Close()
+
+	clnt, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Unable to dial xray server: %v", err)
+	}
+	defer clnt.Close()
+
+	// Acknowledge every command received.
+	go func() {
+		for {
+			var cmd XrayCommand
+			if err := clnt.ReadJSON(&cmd); err != nil {
+				return
+			}
+			ack := fmt.Sprintf(`{"client_uuid": "cam", "commandAck": {"CommandId": %d, "status": "ok"}}`, cmd.CommandID)
+			clnt.WriteMessage(websocket.TextMessage, []byte(ack))
+		}
+	}()
+
+	var wc *wConn
+	for wc == nil {
+		xray.RLock()
+		for c := range xray.conns {
+			wc = c
+		}
+		xray.RUnlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err = wc.SendCommand(XrayCommand{Command: cmdSetResolution}); err != errInvalidCommand {
+		t.Errorf("Expected %v, got %v", errInvalidCommand, err)
+	}
+
+	cs, err := wc.SendCommand(XrayCommand{Command: cmdSetResolution, Width: 1280, Height: 720})
+	if err != nil {
+		t.Fatalf("Unable to send command: %v", err)
+	}
+	status := wc.WaitCommand(cs)
+	if status.Status != cmdStatusOK || status.AckedAt == nil {
+		t.Errorf("Expected command to be acknowledged, got %#v", status)
+	}
+	if list := wc.commands.List(); len(list) != 1 || list[0].Command.CommandID != cs.Command.CommandID {
+		t.Errorf("Expected acknowledged command in history, got %#v", list)
+	}
+}
+
+func TestMessageRouting(t *testing.T) {
+	testCases := []struct {
+		data                      string
+		capabilities, ack, sensor bool
+	}{
+		{`{"client_uuid": "cam", "capabilities": {"minZoom": "1", "maxZoom": "4"}}`, true, false, false},
+		{`{"client_uuid": "cam", "commandAck": {"CommandId": 1, "status": "ok"}}`, false, true, false},
+		{`{"sensorName": "accelerometer", "values": []}`, false, false, true},
+		// Frame records merely carrying the keys as values are frames.
+		{`{"client_uuid": "cam", "frame": {"id": "1"}, "barcodes": [{"value": "\"capabilities\" \"commandAck\" sensorName"}]}`, false, false, false},
+		{`not json`, false, false, false},
+	}
+	for i, testCase := range testCases {
+		keys := parseMessageKeys([]byte(testCase.data))
+		if isCapabilities(keys) != testCase.capabilities || isCommandAck(keys) != testCase.ack || keys.has("sensorName") != testCase.sensor {
+			t.Errorf("Test %d: unexpected routing of %s", i+1, testCase.data)
+		}
+	}
+}
+
+func TestParseCapabilities(t *testing.T) {
+	testCases := []struct {
+		minZoom, maxZoom string
+		expectedErr      error
+	}{
+		{"1", "4", nil},
+		{"0", "4", errInvalidCapabilities},
+		{"4", "1", errInvalidCapabilities},
+		{"NaN", "4", errInvalidCapabilities},
+		{"1", "NaN", errInvalidCapabilities},
+		{"1", "Inf", errInvalidCapabilities},
+		{"-Inf", "4", errInvalidCapabilities},
+	}
+	for i, testCase := range testCases {
+		data := fmt.Sprintf(`{"client_uuid": "cam", "capabilities": {"minZoom": %q, "maxZoom": %q}}`, testCase.minZoom, testCase.maxZoom)
+		caps, err := parseCapabilities([]byte(data))
+		if err != testCase.expectedErr {
+			t.Errorf("Test %d: expected error %v, got %v", i+1, testCase.expectedErr, err)
+			continue
+		}
+		if err == nil && (caps.MinZoom != 1 || caps.MaxZoom != 4) {
+			t.Errorf("Test %d: expected zoom range 1-4, got %g-%g", i+1, caps.MinZoom, caps.MaxZoom)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Policy modes for snapshots triggered by faces.
+const (
+	// Every trigger is uploaded and alerted, the default.
+	policyRecordAll = "record-all"
+
+	// Triggers with only known people are alerted but not uploaded.
+	policySkipKnown = "skip-known"
+
+	// Only triggers with unknown people are uploaded and alerted.
+	policyUnknownOnly = "unknown-only"
+)
+
+// clientPolicy decides which face triggers of a client are uploaded
+// and alerted, based on the people recognized in the gallery.
+type clientPolicy struct {
+	Mode string `json:"mode"`
+
+	// Person ids always uploaded and alerted whatever the mode.
+	Always []string `json:"always,omitempty"`
+}
+
+var defaultClientPolicy = clientPolicy{Mode: policyRecordAll}
+
+// Validates the policy mode.
+func (p clientPolicy) validate() error {
+	switch p.Mode {
+	case policyRecordAll, policySkipKnown, policyUnknownOnly:
+		return nil
+	}
+	return fmt.Errorf("Unknown policy mode %q", p.Mode)
+}
+
+// Evaluate returns whether a trigger with the given faces is
+// uploaded and alerted.
+func (p clientPolicy) Evaluate(ids []XrayIdentity) (upload, alert bool) {
+	unknown := false
+	for _, id := range ids {
+		if id.PersonID == unknownIdentity {
+			unknown = true
+			continue
+		}
+		for _, always := range p.Always {
+			if id.PersonID == always {
+				return true, true
+			}
+		}
+	}
+
+	switch p.Mode {
+	case policySkipKnown:
+		return unknown, true
+	case policyUnknownOnly:
+		return unknown, unknown
+	}
+	return true, true
+}
+
+var (
+	policyMu  sync.Mutex
+	policyMap = make(map[string]clientPolicy)
+)
+
+func getPolicyForClient(clientID string) clientPolicy {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	if p, ok := policyMap[clientID]; ok {
+		return p
+	}
+	return defaultClientPolicy
+}
+
+func setPolicyForClient(clientID string, p clientPolicy) {
+	policyMu.Lock()
+	policyMap[clientID] = p
+	policyMu.Unlock()
+}
+
+// Restores the default policy of a client.
+func resetPolicyForClient(clientID string) {
+	policyMu.Lock()
+	delete(policyMap, clientID)
+	policyMu.Unlock()
+}
+
+// Applies the client policy to the face trigger of a frame, before
+// any snapshot is uploaded. Face events are dropped when not alerted
+// and all the triggering classes are removed when not uploaded,
+// returns the remaining events.
+func applyClientPolicy(log *logrus.Entry, fr *frameRecord, snap *snapshot, events []XrayEvent, triggers map[string]bool) []XrayEvent {
+	if !triggers[classFace] {
+		return events
+	}
+
+	upload, alert := getPolicyForClient(fr.ClientID).Evaluate(snap.identities(len(fr.Faces)))
+	if !alert {
+		filtered := events[:0]
+		for _, event := range events {
+			if event.Class != classFace {
+				filtered = append(filtered, event)
+			}
+		}
+		events = filtered
+	}
+	if !upload {
+		log.Info("Snapshot suppressed by client policy")
+		globalMetrics.policySuppressed.Inc(fr.ClientID)
+
+		// The frame shows the faces, other classes do not upload it
+		// either.
+		for class := range triggers {
+			delete(triggers, class)
+		}
+	}
+	return events
+}
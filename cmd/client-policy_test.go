@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestClientPolicy(t *testing.T) {
+	alice := XrayIdentity{PersonID: "alice"}
+	bob := XrayIdentity{PersonID: "bob"}
+	unknown := XrayIdentity{PersonID: unknownIdentity}
+
+	testCases := []struct {
+		policy        clientPolicy
+		ids           []XrayIdentity
+		upload, alert bool
+	}{
+		{defaultClientPolicy, []XrayIdentity{alice}, true, true},
+		{clientPolicy{Mode: policySkipKnown}, []XrayIdentity{alice, bob}, false, true},
+		{clientPolicy{Mode: policySkipKnown}, []XrayIdentity{alice, unknown}, true, true},
+		{clientPolicy{Mode: policyUnknownOnly}, []XrayIdentity{alice}, false, false},
+		{clientPolicy{Mode: policyUnknownOnly}, []XrayIdentity{unknown}, true, true},
+		{clientPolicy{Mode: policyUnknownOnly, Always: []string{"bob"}}, []XrayIdentity{alice, bob}, true, true},
+		// Faces which could not be recognized are unknown.
+		{clientPolicy{Mode: policyUnknownOnly}, (*snapshot)(nil).identities(1), true, true},
+	}
+	for i, testCase := range testCases {
+		upload, alert := testCase.policy.Evaluate(testCase.ids)
+		if upload != testCase.upload || alert != testCase.alert {
+			t.Errorf("Test %d: expected upload %v alert %v, got %v %v", i+1,
+				testCase.upload, testCase.alert, upload, alert)
+		}
+	}
+
+	if err := (clientPolicy{Mode: "never"}).validate(); err == nil {
+		t.Error("Expected unknown mode to be rejected")
+	}
+}
+
+func TestApplyClientPolicy(t *testing.T) {
+	const clientID = "apply-client-policy"
+	setPolicyForClient(clientID, clientPolicy{Mode: policySkipKnown})
+	defer resetPolicyForClient(clientID)
+
+	alice := XrayIdentity{PersonID: "alice"}
+	snap := &snapshot{
+		thumbnail: "snap/thumbnail.jpg",
+		crops:     []XrayCrop{{FaceID: "1", ObjectName: "snap/face-1.jpg", Identity: &alice}},
+	}
+	fr := &frameRecord{ClientID: clientID, Faces: []faceStruct{{ID: "1"}}}
+	events := []XrayEvent{
+		{Type: eventMotion, Class: classFace},
+		{Type: eventBarcodeScanned, Class: classBarcode},
+	}
+	triggers := map[string]bool{classFace: true, classBarcode: true}
+
+	// The barcode is alerted but does not upload the frame showing
+	// the known faces.
+	events = applyClientPolicy(logrus.NewEntry(logrus.New()), fr, snap, events, triggers)
+	if len(events) != 2 {
+		t.Errorf("Expected known faces to be alerted, got %v", events)
+	}
+	if len(triggers) != 0 {
+		t.Errorf("Expected no class to trigger, got %v", triggers)
+	}
+}
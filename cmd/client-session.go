@@ -0,0 +1,182 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var lastConnID uint64
+
+// clientSession represents the state of a single websocket
+// connection, exposed through the admin API.
+type clientSession struct {
+	mu sync.RWMutex
+
+	connID         uint64
+	clientID       string
+	remoteAddr     string
+	connectTime    time.Time
+	framesReceived uint64
+	lastZoom       int
+	lastMotion     time.Time
+
+	// Camera capabilities, the last absolute zoom ratio sent and
+	// the zoom ratio it converges to.
+	caps          deviceCapabilities
+	zoomRatio     float64
+	zoomTarget    float64
+	zoomReached   time.Time
+	zoomRatioCtrl *zoomController
+}
+
+// sessionInfo is the JSON representation of a client session.
+type sessionInfo struct {
+	ConnID         uint64             `json:"connId"`
+	ClientID       string             `json:"clientId"`
+	RemoteAddr     string             `json:"remoteAddr"`
+	ConnectTime    time.Time          `json:"connectTime"`
+	FramesReceived uint64             `json:"framesReceived"`
+	LastZoom       int                `json:"lastZoom"`
+	ZoomRatio      float64            `json:"zoomRatio"`
+	Capabilities   deviceCapabilities `json:"capabilities"`
+	LastMotion     *time.Time         `json:"lastMotion,omitempty"`
+}
+
+// Initializes a new session with a connection id unique for
+// the lifetime of the server.
+func newClientSession(remoteAddr string) *clientSession {
+	return &clientSession{
+		connID:        atomic.AddUint64(&lastConnID, 1),
+		remoteAddr:    remoteAddr,
+		connectTime:   time.Now().UTC(),
+		caps:          defaultDeviceCapabilities,
+		zoomRatio:     defaultDeviceCapabilities.MinZoom,
+		zoomTarget:    defaultDeviceCapabilities.MinZoom,
+		zoomRatioCtrl: newZoomController(defaultZoomRatioControllerOptions),
+	}
+}
+
+// newLog returns a log entry carrying the connection id.
+func (s *clientSession) newLog() *logrus.Entry {
+	return rlog.WithField(logFieldConnID, s.connID)
+}
+
+// ClientID returns the client id reported by the camera.
+func (s *clientSession) ClientID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.clientID
+}
+
+// recordFrame records an incoming frame from clientID.
+func (s *clientSession) recordFrame(clientID string) {
+	s.mu.Lock()
+	s.clientID = clientID
+	s.framesReceived++
+	s.mu.Unlock()
+}
+
+// recordResult records the result sent back to the client.
+func (s *clientSession) recordResult(zoom int, motionDetected bool) {
+	s.mu.Lock()
+	s.lastZoom = zoom
+	if motionDetected {
+		s.lastMotion = time.Now().UTC()
+	}
+	s.mu.Unlock()
+}
+
+// setCapabilities records the capabilities announced by the client.
+func (s *clientSession) setCapabilities(caps deviceCapabilities) {
+	s.mu.Lock()
+	s.caps = caps
+	s.zoomRatio = caps.clampZoom(s.zoomRatio)
+	s.zoomTarget = caps.clampZoom(s.zoomTarget)
+	s.mu.Unlock()
+}
+
+// mapZoomRatio converts a zoom ratio relative to the zoom of the
+// device into an absolute zoom within the zoom range of the device,
+// smoothed across frames. deviceZoom is the zoom reported with the
+// frame. If zero the zoom of the frame is unknown, the target is then
+// derived from the zoom sent only once the previous target was
+// reached and held long enough for the device to apply it, not on
+// every frame. Without a relative ratio the last target is held.
+func (s *clientSession) mapZoomRatio(relative float64, ok bool, deviceZoom float64, now time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case !ok:
+		// Hold the last target.
+	case deviceZoom > 0:
+		s.zoomTarget = s.caps.clampZoom(deviceZoom * relative)
+	case s.zoomRatio == s.zoomTarget && (s.zoomReached.IsZero() ||
+		now.Sub(s.zoomReached) >= defaultZoomRatioControllerOptions.Dwell):
+		s.zoomTarget = s.caps.clampZoom(s.zoomRatio * relative)
+	}
+	s.zoomRatio = s.caps.clampZoom(s.zoomRatioCtrl.Update(s.zoomTarget, now))
+	switch {
+	case s.zoomRatio != s.zoomTarget:
+		s.zoomReached = time.Time{}
+	case s.zoomReached.IsZero():
+		s.zoomReached = now
+	}
+	return s.zoomRatio
+}
+
+// reset clears all the per frame state of the session.
+func (s *clientSession) reset() {
+	s.mu.Lock()
+	s.framesReceived = 0
+	s.lastZoom = 0
+	s.lastMotion = time.Time{}
+	s.zoomRatio = s.caps.MinZoom
+	s.zoomTarget = s.caps.MinZoom
+	s.zoomReached = time.Time{}
+	s.zoomRatioCtrl = newZoomController(defaultZoomRatioControllerOptions)
+	s.mu.Unlock()
+}
+
+// Info returns a snapshot of the session.
+func (s *clientSession) Info() sessionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	info := sessionInfo{
+		ConnID:         s.connID,
+		ClientID:       s.clientID,
+		RemoteAddr:     s.remoteAddr,
+		ConnectTime:    s.connectTime,
+		FramesReceived: s.framesReceived,
+		LastZoom:       s.lastZoom,
+		ZoomRatio:      s.zoomRatio,
+		Capabilities:   s.caps,
+	}
+	if !s.lastMotion.IsZero() {
+		lastMotion := s.lastMotion
+		info.LastMotion = &lastMotion
+	}
+	return info
+}
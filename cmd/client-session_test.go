@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMapZoomRatio(t *testing.T) {
+	now := time.Now()
+
+	// Without the device zoom, the relative ratio of every frame
+	// is not compounded onto the zoom still converging.
+	s := newClientSession("test")
+	var zoom float64
+	for i := 0; i < 10; i++ {
+		zoom = s.mapZoomRatio(2, true, 0, now)
+		if zoom > 2 {
+			t.Fatalf("Frame %d: expected zoom up to 2 until reached, got %v", i, zoom)
+		}
+		now = now.Add(frameInterval)
+	}
+
+	// With the device zoom the target follows every frame.
+	s = newClientSession("test")
+	for i := 0; i < 90; i++ {
+		zoom = s.mapZoomRatio(1.5, true, 2, now)
+		now = now.Add(frameInterval)
+	}
+	if zoom != 3 {
+		t.Fatalf("Expected zoom to converge to 3, got %v", zoom)
+	}
+
+	// Frames without detections hold the last target.
+	for i := 0; i < 30; i++ {
+		if zoom = s.mapZoomRatio(0, false, 0, now); zoom != 3 {
+			t.Fatalf("Frame %d: expected zoom to hold at 3, got %v", i, zoom)
+		}
+		now = now.Add(frameInterval)
+	}
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Frame dropping policies of the client queues.
+const (
+	// Full queues drop their oldest frame, the default.
+	dropOldest = "drop-oldest"
+
+	// Only the latest frame of a client waits for a worker.
+	keepLatest = "keep-latest"
+)
+
+// Weight of the latest detection in the average detection time.
+const detectionCostWeight = 0.1
+
+// Returns the number of detection workers.
+func getDetectWorkers() int {
+	if n := envInt("DETECT_WORKERS", runtime.NumCPU()); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// Returns the number of frames a client may have waiting for a worker.
+func getDetectQueueSize() int {
+	if n := envInt("DETECT_QUEUE_SIZE", 4); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// Returns the frame dropping policy of the client queues.
+func getDropPolicy() string {
+	if os.Getenv("DETECT_DROP_POLICY") == keepLatest {
+		return keepLatest
+	}
+	return dropOldest
+}
+
+// XrayBackpressure - asks the client to slow down, sent along
+// with results once frames of the client were dropped or the
+// server is saturated.
+type XrayBackpressure struct {
+	// Frames of the client dropped since the last result.
+	Dropped int
+
+	// Ratio of frames waiting for detection to the frames all
+	// clients may queue.
+	Saturation float64
+
+	// Suggested maximum frame rate of the client, zero if unknown.
+	FrameRate float64 `json:",omitempty"`
+}
+
+// detectionJob is a frame record waiting for a worker.
+type detectionJob struct {
+	data     []byte
+	received time.Time
+}
+
+// clientQueue holds the frames of a connection waiting for a worker,
+// frames of a connection are detected one at a time and in order.
+type clientQueue struct {
+	wc   *wConn
+	jobs []detectionJob
+
+	// Set while the queue is on the ready list or being detected.
+	scheduled bool
+
+	// Frames dropped since the last result.
+	dropped int
+}
+
+// detectionPool runs the detections of all the connections on a
+// fixed number of workers, connections take turns.
+type detectionPool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queues map[*wConn]*clientQueue
+	ready  []*clientQueue
+	queued int
+	closed bool
+
+	workers   int
+	queueSize int
+	policy    string
+
+	// Average time taken by a detection in seconds.
+	cost float64
+
+	// Detects a frame, and is called once done with every frame
+	// either detected or dropped.
+	detect func(wc *wConn, data []byte, received time.Time, bp *XrayBackpressure)
+	done   func()
+}
+
+// Starts a new pool of workers.
+func newDetectionPool(workers, queueSize int, policy string, detect func(*wConn, []byte, time.Time, *XrayBackpressure), done func()) *detectionPool {
+	if policy == keepLatest {
+		queueSize = 1
+	}
+	p := &detectionPool{
+		queues:    make(map[*wConn]*clientQueue),
+		workers:   workers,
+		queueSize: queueSize,
+		policy:    policy,
+		detect:    detect,
+		done:      done,
+	}
+	p.cond = sync.NewCond(&p.mu)
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+	return p
+}
+
+// Submit queues a frame of a connection received at the given time,
+// dropping older frames of the connection if its queue is full.
+func (p *detectionPool) Submit(wc *wConn, data []byte, received time.Time) {
+	p.mu.Lock()
+	q, ok := p.queues[wc]
+	if !ok {
+		q = &clientQueue{wc: wc}
+		p.queues[wc] = q
+	}
+	dropped := 0
+	for len(q.jobs) >= p.queueSize {
+		q.jobs = q.jobs[1:]
+		dropped++
+	}
+	q.dropped += dropped
+	q.jobs = append(q.jobs, detectionJob{data, received})
+	p.queued += 1 - dropped
+	if !q.scheduled {
+		q.scheduled = true
+		p.ready = append(p.ready, q)
+		p.cond.Signal()
+	}
+	p.mu.Unlock()
+
+	p.drop(wc, dropped)
+}
+
+// Remove drops the frames waiting for a closed connection.
+func (p *detectionPool) Remove(wc *wConn) {
+	p.mu.Lock()
+	q, ok := p.queues[wc]
+	dropped := 0
+	if ok {
+		dropped = len(q.jobs)
+		p.queued -= dropped
+		q.jobs = nil
+		delete(p.queues, wc)
+	}
+	p.mu.Unlock()
+
+	p.drop(wc, dropped)
+}
+
+// Records n dropped frames of a connection.
+func (p *detectionPool) drop(wc *wConn, n int) {
+	if n == 0 {
+		return
+	}
+	globalMetrics.framesDropped.Add(wc.session.ClientID(), float64(n))
+	for i := 0; i < n; i++ {
+		p.done()
+	}
+}
+
+// Close stops the workers once all the queued frames are detected.
+func (p *detectionPool) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mu.Unlock()
+}
+
+// Queued returns the number of frames waiting for a worker and the
+// number of frames the connections may queue.
+func (p *detectionPool) Queued() (queued, capacity int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queued, len(p.queues) * p.queueSize
+}
+
+// Returns the backpressure of a queue, nil unless frames were dropped
+// or the pool is saturated. Must be called with the lock held.
+func (p *detectionPool) backpressure(q *clientQueue) *XrayBackpressure {
+	capacity := len(p.queues) * p.queueSize
+	saturation := 0.0
+	if capacity > 0 {
+		saturation = float64(p.queued) / float64(capacity)
+	}
+	if q.dropped == 0 && saturation <= maxQueueSaturation {
+		return nil
+	}
+
+	// Share the throughput of the workers between the connections.
+	bp := &XrayBackpressure{Dropped: q.dropped, Saturation: saturation}
+	if p.cost > 0 && len(p.queues) > 0 {
+		bp.FrameRate = float64(p.workers) / (p.cost * float64(len(p.queues)))
+	}
+	q.dropped = 0
+	return bp
+}
+
+func (p *detectionPool) worker() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		for len(p.ready) == 0 && !p.closed {
+			p.cond.Wait()
+		}
+		if len(p.ready) == 0 {
+			return
+		}
+		q := p.ready[0]
+		p.ready = p.ready[1:]
+		if len(q.jobs) == 0 {
+			q.scheduled = false
+			continue
+		}
+		job := q.jobs[0]
+		q.jobs = q.jobs[1:]
+		p.queued--
+		bp := p.backpressure(q)
+		p.mu.Unlock()
+
+		start := time.Now()
+		p.detect(q.wc, job.data, job.received, bp)
+		p.done()
+
+		p.mu.Lock()
+		cost := time.Since(start).Seconds()
+		if p.cost == 0 {
+			p.cost = cost
+		} else {
+			p.cost += detectionCostWeight * (cost - p.cost)
+		}
+		if len(q.jobs) > 0 {
+			p.ready = append(p.ready, q)
+			p.cond.Signal()
+		} else {
+			q.scheduled = false
+		}
+	}
+}
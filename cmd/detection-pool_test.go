@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// detectionRecorder records the frames detected by a pool, every
+// detection blocks until released.
+type detectionRecorder struct {
+	mu       sync.Mutex
+	frames   []string
+	pressure []*XrayBackpressure
+	release  chan struct{}
+	started  chan struct{}
+	done     sync.WaitGroup
+}
+
+func newDetectionRecorder() *detectionRecorder {
+	return &detectionRecorder{
+		release: make(chan struct{}),
+		started: make(chan struct{}, 100),
+	}
+}
+
+func (r *detectionRecorder) detect(wc *wConn, data []byte, received time.Time, bp *XrayBackpressure) {
+	r.started <- struct{}{}
+	<-r.release
+	r.mu.Lock()
+	r.frames = append(r.frames, string(data))
+	r.pressure = append(r.pressure, bp)
+	r.mu.Unlock()
+}
+
+// Submits a frame to the pool, accounted as an in-flight detection.
+func (r *detectionRecorder) submit(p *detectionPool, wc *wConn, frame string) {
+	r.done.Add(1)
+	p.Submit(wc, []byte(frame), time.Now())
+}
+
+func newTestConn() *wConn {
+	return &wConn{session: newClientSession("test")}
+}
+
+func TestDetectionPoolOrder(t *testing.T) {
+	r := newDetectionRecorder()
+	p := newDetectionPool(1, 4, dropOldest, r.detect, r.done.Done)
+	defer p.Close()
+	close(r.release)
+
+	// Connections take turns, frames of a connection are in order.
+	wc1, wc2 := newTestConn(), newTestConn()
+	r.submit(p, wc1, "a1")
+	<-r.started
+	r.submit(p, wc1, "a2")
+	r.submit(p, wc2, "b1")
+	r.submit(p, wc1, "a3")
+	r.submit(p, wc2, "b2")
+	r.done.Wait()
+
+	if len(r.frames) != 5 {
+		t.Fatalf("Expected 5 frames, got %v", r.frames)
+	}
+	a, b := []string{}, []string{}
+	for _, f := range r.frames {
+		if f[0] == 'a' {
+			a = append(a, f)
+		} else {
+			b = append(b, f)
+		}
+	}
+	if !reflect.DeepEqual(a, []string{"a1", "a2", "a3"}) || !reflect.DeepEqual(b, []string{"b1", "b2"}) {
+		t.Errorf("Expected frames of a connection in order, got %v", r.frames)
+	}
+	if queued, _ := p.Queued(); queued != 0 {
+		t.Errorf("Expected no queued frames, got %d", queued)
+	}
+}
+
+func TestDetectionPoolDropping(t *testing.T) {
+	testCases := []struct {
+		policy   string
+		frames   []string
+		dropped  int
+		expected []string
+	}{
+		{dropOldest, []string{"1", "2", "3", "4", "5", "6"}, 3, []string{"1", "5", "6"}},
+		{keepLatest, []string{"1", "2", "3", "4", "5", "6"}, 4, []string{"1", "6"}},
+		{dropOldest, []string{"1", "2"}, 0, []string{"1", "2"}},
+	}
+	for i, testCase := range testCases {
+		r := newDetectionRecorder()
+		p := newDetectionPool(1, 2, testCase.policy, r.detect, r.done.Done)
+		wc := newTestConn()
+
+		// Hold the worker on the first frame while the others queue.
+		r.submit(p, wc, testCase.frames[0])
+		<-r.started
+		for _, frame := range testCase.frames[1:] {
+			r.submit(p, wc, frame)
+		}
+		close(r.release)
+		r.done.Wait()
+		p.Close()
+
+		if !reflect.DeepEqual(r.frames, testCase.expected) {
+			t.Errorf("Test %d: expected frames %v, got %v", i+1, testCase.expected, r.frames)
+		}
+		bp := r.pressure[1]
+		if testCase.dropped == 0 {
+			if bp != nil {
+				t.Errorf("Test %d: expected no backpressure, got %+v", i+1, bp)
+			}
+			continue
+		}
+		if bp == nil || bp.Dropped != testCase.dropped {
+			t.Errorf("Test %d: expected %d dropped frames, got %+v", i+1, testCase.dropped, bp)
+			continue
+		}
+		if bp.FrameRate <= 0 {
+			t.Errorf("Test %d: expected a suggested frame rate, got %+v", i+1, bp)
+		}
+		if len(r.pressure) > 2 && r.pressure[2] != nil {
+			t.Errorf("Test %d: expected dropped frames to be reported once", i+1)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"image"
+	"sync"
+	"time"
+
+	gocv "github.com/minio/go-cv"
+)
+
+// Width of the grayscale thumbnails frames are compared on.
+const changeThumbnailWidth = 64
+
+// Window over which the effective detection rate is measured.
+const detectionRateWindow = 10 * time.Second
+
+// Returns the mean absolute difference per thumbnail pixel above
+// which a frame is detected again.
+func getChangeThreshold() float64 {
+	return envFloat("DETECT_CHANGE_THRESHOLD", 2)
+}
+
+// Returns the interval between two detections of a static scene.
+func getStaticDetectInterval() time.Duration {
+	return time.Duration(envFloat("DETECT_STATIC_INTERVAL", 2) * float64(time.Second))
+}
+
+// Returns a downscaled grayscale copy of a view to detect changes on.
+func changeThumbnail(src *gocv.View) *gocv.View {
+	w, h := src.Width(), src.Height()
+	if w > changeThumbnailWidth {
+		w, h = changeThumbnailWidth, (h*changeThumbnailWidth+src.Width()/2)/src.Width()
+		if h < 1 {
+			h = 1
+		}
+	}
+
+	gray := src
+	if src.Format() != gocv.GRAY8 {
+		gray = gocv.NewView(src.Width(), src.Height(), gocv.GRAY8)
+		defer gray.Close()
+		gocv.BgraToGray(*src, *gray)
+	}
+	thumb := gocv.NewView(w, h, gocv.GRAY8)
+	gocv.ResizeBilinear(*gray, *thumb)
+	return thumb
+}
+
+// detectionScheduler decides which frames of a client are detected,
+// frames are detected again once the scene changed or the static
+// interval elapsed and the faces found are reused in between.
+type detectionScheduler struct {
+	mu sync.Mutex
+
+	// Thumbnail of the last frame detected and the faces found.
+	thumb      *gocv.View
+	faces      []image.Rectangle
+	lastDetect time.Time
+
+	// Times of the detections within the rate window.
+	detections []time.Time
+}
+
+// Returns the mean absolute difference of two thumbnails, -1 if
+// they cannot be compared.
+func thumbnailChange(a, b *gocv.View) float64 {
+	if a == nil || b == nil || a.Width() != b.Width() || a.Height() != b.Height() {
+		return -1
+	}
+	return float64(gocv.AbsDifferenceSum(*a, *b)) / float64(a.Width()*a.Height())
+}
+
+// Schedule returns whether the frame with the given thumbnail is
+// detected.
+func (s *detectionScheduler) Schedule(thumb *gocv.View, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastDetect) >= getStaticDetectInterval() {
+		return true
+	}
+	change := thumbnailChange(s.thumb, thumb)
+	return change < 0 || change > getChangeThreshold()
+}
+
+// Detected records the faces detected on the frame with the given
+// thumbnail, the scheduler takes ownership of the thumbnail.
+func (s *detectionScheduler) Detected(thumb *gocv.View, faces []image.Rectangle, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.thumb != nil {
+		s.thumb.Close()
+	}
+	s.thumb = thumb
+	s.faces = faces
+	s.lastDetect = now
+	s.detections = append(s.trimDetections(now), now)
+}
+
+// Tracked returns the faces of the last detection.
+func (s *detectionScheduler) Tracked() []image.Rectangle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]image.Rectangle{}, s.faces...)
+}
+
+// Rate returns the effective number of detections per second.
+func (s *detectionScheduler) Rate(now time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.detections = s.trimDetections(now)
+	return float64(len(s.detections)) / detectionRateWindow.Seconds()
+}
+
+// Drops the detections older than the rate window.
+func (s *detectionScheduler) trimDetections(now time.Time) []time.Time {
+	i := 0
+	for i < len(s.detections) && now.Sub(s.detections[i]) >= detectionRateWindow {
+		i++
+	}
+	return s.detections[i:]
+}
+
+var (
+	schedulerMu  sync.Mutex
+	schedulerMap = make(map[string]*detectionScheduler)
+)
+
+func getDetectionSchedulerForClient(clientID string) *detectionScheduler {
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+	s, ok := schedulerMap[clientID]
+	if !ok {
+		s = &detectionScheduler{}
+		schedulerMap[clientID] = s
+	}
+	return s
+}
+
+// Returns the detection scheduler of a client without creating it,
+// false if nothing was detected on the server for the client.
+func lookupDetectionSchedulerForClient(clientID string) (*detectionScheduler, bool) {
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+	s, ok := schedulerMap[clientID]
+	return s, ok
+}
+
+// Drops the detection schedule of a client, its next frame is
+// detected.
+func resetDetectionSchedulerForClient(clientID string) {
+	schedulerMu.Lock()
+	delete(schedulerMap, clientID)
+	schedulerMu.Unlock()
+}
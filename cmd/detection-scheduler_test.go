@@ -0,0 +1,99 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"image"
+	"reflect"
+	"testing"
+	"time"
+
+	gocv "github.com/minio/go-cv"
+)
+
+func TestChangeThumbnail(t *testing.T) {
+	img, err := readPGM("../contrib/Simd/data/image/face/lena.pgm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := gocv.ViewFromImage(img)
+	defer gray.Close()
+	rgba := gocv.ViewFromImage(gocv.AsRGBA(img))
+	defer rgba.Close()
+
+	a, b := changeThumbnail(gray), changeThumbnail(rgba)
+	defer a.Close()
+	defer b.Close()
+	if a.Bounds() != image.Rect(0, 0, changeThumbnailWidth, changeThumbnailWidth) || a.Format() != gocv.GRAY8 {
+		t.Fatalf("Expected a %dx%d gray thumbnail, got %v", changeThumbnailWidth, changeThumbnailWidth, a.Bounds())
+	}
+	if change := thumbnailChange(a, b); change < 0 || change > 1 {
+		t.Errorf("Expected gray and color thumbnails to match, got change %v", change)
+	}
+}
+
+func TestDetectionScheduler(t *testing.T) {
+	newThumb := func(value uint8) *gocv.View {
+		thumb := gocv.NewView(changeThumbnailWidth, 48, gocv.GRAY8)
+		gocv.Fill(*thumb, value)
+		return thumb
+	}
+	faces := []image.Rectangle{image.Rect(10, 10, 50, 50)}
+	now := time.Now()
+
+	s := &detectionScheduler{}
+	if !s.Schedule(newThumb(100), now) {
+		t.Fatal("Expected the first frame to be detected")
+	}
+	s.Detected(newThumb(100), faces, now)
+
+	testCases := []struct {
+		value    uint8
+		after    time.Duration
+		detected bool
+	}{
+		// Static scenes reuse the faces found.
+		{100, 100 * time.Millisecond, false},
+		{101, 100 * time.Millisecond, false},
+		// Changed scenes are detected again.
+		{120, 100 * time.Millisecond, true},
+		// Static scenes are detected again once the interval elapsed.
+		{100, getStaticDetectInterval(), true},
+	}
+	for i, testCase := range testCases {
+		thumb := newThumb(testCase.value)
+		if detected := s.Schedule(thumb, now.Add(testCase.after)); detected != testCase.detected {
+			t.Errorf("Test %d: expected detected %v, got %v", i+1, testCase.detected, detected)
+		}
+		thumb.Close()
+	}
+	if tracked := s.Tracked(); !reflect.DeepEqual(tracked, faces) {
+		t.Errorf("Expected tracked faces %v, got %v", faces, tracked)
+	}
+
+	s.Detected(newThumb(120), nil, now.Add(time.Second))
+	s.Detected(newThumb(140), nil, now.Add(2*time.Second))
+	if rate := s.Rate(now.Add(2 * time.Second)); rate != 3/detectionRateWindow.Seconds() {
+		t.Errorf("Expected rate %v, got %v", 3/detectionRateWindow.Seconds(), rate)
+	}
+	if rate := s.Rate(now.Add(time.Second + detectionRateWindow)); rate != 1/detectionRateWindow.Seconds() {
+		t.Errorf("Expected rate %v, got %v", 1/detectionRateWindow.Seconds(), rate)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+)
+
+var errInvalidCapabilities = errors.New("Invalid device capabilities")
+
+// deviceCapabilities describes the camera of a client, announced
+// by the client right after connecting.
+type deviceCapabilities struct {
+	MinZoom float64 `json:"minZoom"`
+	MaxZoom float64 `json:"maxZoom"`
+}
+
+// Capabilities assumed until a client announces its own.
+var defaultDeviceCapabilities = deviceCapabilities{
+	MinZoom: 1,
+	MaxZoom: defaultFramingMaxZoom,
+}
+
+// capabilitiesRecord is the capabilities announcement of a client.
+type capabilitiesRecord struct {
+	ClientID     string `json:"client_uuid"`
+	Capabilities struct {
+		MinZoom string `json:"minZoom"`
+		MaxZoom string `json:"maxZoom"`
+	} `json:"capabilities"`
+}
+
+// Returns true if the incoming message announces capabilities.
+func isCapabilities(keys messageKeys) bool {
+	return keys.has("capabilities")
+}
+
+// Parses and validates a capabilities announcement.
+func parseCapabilities(data []byte) (deviceCapabilities, error) {
+	var cr capabilitiesRecord
+	if err := json.Unmarshal(data, &cr); err != nil {
+		return deviceCapabilities{}, err
+	}
+	minZoom, err := strconv.ParseFloat(cr.Capabilities.MinZoom, 64)
+	if err != nil {
+		return deviceCapabilities{}, err
+	}
+	maxZoom, err := strconv.ParseFloat(cr.Capabilities.MaxZoom, 64)
+	if err != nil {
+		return deviceCapabilities{}, err
+	}
+	if math.IsNaN(minZoom) || math.IsNaN(maxZoom) || math.IsInf(minZoom, 0) || math.IsInf(maxZoom, 0) ||
+		minZoom <= 0 || maxZoom < minZoom {
+		return deviceCapabilities{}, errInvalidCapabilities
+	}
+	return deviceCapabilities{MinZoom: minZoom, MaxZoom: maxZoom}, nil
+}
+
+// Handles a capabilities announcement from the client.
+func (w *wConn) handleCapabilities(data []byte) {
+	caps, err := parseCapabilities(data)
+	if err != nil {
+		entryErrorIf(w.log, err, "Unable to parse device capabilities")
+		return
+	}
+	w.log.Infof("Device zoom range %g-%g", caps.MinZoom, caps.MaxZoom)
+	w.session.setCapabilities(caps)
+}
+
+// Clamps zoom into the zoom range of the device.
+func (caps deviceCapabilities) clampZoom(zoom float64) float64 {
+	return math.Max(caps.MinZoom, math.Min(zoom, caps.MaxZoom))
+}
@@ -0,0 +1,275 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"image"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	gocv "github.com/minio/go-cv"
+)
+
+// Mask values of ink and background points.
+const (
+	ocrInk        = 255
+	ocrBackground = 0
+)
+
+// Characters are scaled to this height within the network input,
+// matching the digits the network was trained on.
+const ocrDigitHeight = 13
+
+// Characters shorter than this share of the tallest character, or
+// than the minimum height in pixels, are treated as noise.
+const (
+	ocrMinHeightRatio = 0.5
+	ocrMinHeight      = 6
+)
+
+// Returns the minimum score for a character to be read, weaker
+// characters are reported as '?'.
+func getOCRMinConfidence() float64 {
+	return envFloat("OCR_MIN_CONFIDENCE", 0.6)
+}
+
+// ocrCharacter is a single character read from a crop.
+type ocrCharacter struct {
+	Rect  image.Rectangle
+	Value byte
+	Score float64
+}
+
+// ocrResult is the text read from a crop, confidence is the score
+// of the weakest character.
+type ocrResult struct {
+	Text       string
+	Confidence float64
+	Characters []ocrCharacter
+}
+
+// Binarizes a gray view into a mask of ink points. Dark text on a
+// light background is assumed first, masks with mostly ink are
+// redone for light text on a dark background.
+func binarizeText(src *gocv.View) *gocv.View {
+	min, max, _ := gocv.GetStatistic(*src)
+	value := uint8((int(min) + int(max) + 1) / 2)
+
+	mask := gocv.NewView(src.Width(), src.Height(), gocv.GRAY8)
+	gocv.AveragingBinarization(*src, value, 1, 127, ocrInk, ocrBackground, *mask, gocv.CompareLesser)
+
+	var ink uint64
+	for _, sum := range gocv.GetColSums(*mask) {
+		ink += uint64(sum)
+	}
+	if ink > uint64(src.Width()*src.Height())*ocrInk/2 {
+		gocv.AveragingBinarization(*src, value, 1, 127, ocrInk, ocrBackground, *mask, gocv.CompareGreaterOrEqual)
+	}
+	gocv.SegmentationFillSingleHoles(*mask, ocrInk)
+	return mask
+}
+
+// Splits a mask into characters along the columns without ink and
+// shrinks every character to the bounds of its ink.
+func segmentCharacters(mask *gocv.View) []image.Rectangle {
+	var rects []image.Rectangle
+	cols := gocv.GetColSums(*mask)
+	for x := 0; x < len(cols); {
+		if cols[x] == 0 {
+			x++
+			continue
+		}
+		start := x
+		for x < len(cols) && cols[x] != 0 {
+			x++
+		}
+		r := gocv.SegmentationShrinkRegion(*mask, ocrInk, image.Rect(start, 0, x, mask.Height()))
+		if !r.Empty() {
+			rects = append(rects, r)
+		}
+	}
+
+	tallest := 0
+	for _, r := range rects {
+		if r.Dy() > tallest {
+			tallest = r.Dy()
+		}
+	}
+	chars := rects[:0]
+	for _, r := range rects {
+		if r.Dy() >= ocrMinHeight && float64(r.Dy()) >= ocrMinHeightRatio*float64(tallest) {
+			chars = append(chars, r)
+		}
+	}
+	return chars
+}
+
+// Renders the ink of a character as a dark on light image of the
+// network input size, scaled to the trained digit height and centred.
+func renderCharacter(mask *gocv.View, r image.Rectangle, width, height int) *image.Gray {
+	h := ocrDigitHeight
+	w := (r.Dx()*h + r.Dy()/2) / r.Dy()
+	if w > width-2 {
+		w = width - 2
+	}
+	if w < 1 {
+		w = 1
+	}
+	dst := gocv.NewView(w, h, gocv.GRAY8)
+	defer dst.Close()
+	gocv.ResizeBilinear(*mask.Region(r), *dst)
+
+	out := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range out.Pix {
+		out.Pix[i] = 0xff
+	}
+	at := image.Pt((width-w)/2, (height-h)/2)
+	pix, stride := dst.Pix(), dst.Stride()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			out.Pix[(at.Y+y)*out.Stride+at.X+x] = 0xff - pix[y*stride+x]
+		}
+	}
+	return out
+}
+
+// Reads the digits of a crop with the digit network.
+func readDigits(net *neuralNetwork, crop image.Image, minConfidence float64) ocrResult {
+	b := crop.Bounds()
+	src := gocv.NewView(b.Dx(), b.Dy(), gocv.GRAY8)
+	defer src.Close()
+	src.CopyFrom(crop)
+
+	mask := binarizeText(src)
+	defer mask.Close()
+
+	var result ocrResult
+	text := make([]byte, 0, 16)
+	for _, r := range segmentCharacters(mask) {
+		label, score := net.Classify(renderCharacter(mask, r, net.width, net.height), true)
+		c := ocrCharacter{Rect: r.Add(b.Min), Value: '?', Score: float64(score)}
+		if label >= 0 && c.Score >= minConfidence {
+			c.Value = byte('0' + label)
+		}
+		if len(result.Characters) == 0 || c.Score < result.Confidence {
+			result.Confidence = c.Score
+		}
+		result.Characters = append(result.Characters, c)
+		text = append(text, c.Value)
+	}
+	result.Text = string(text)
+	return result
+}
+
+// digitReader reads digits with a network loaded once at startup.
+type digitReader struct {
+	mutex sync.RWMutex
+	net   *neuralNetwork
+}
+
+var globalDigitReader = &digitReader{}
+
+// Open loads the digit network from a Simd network file.
+func (d *digitReader) Open(path string) error {
+	net := newDigitNetwork()
+	if err := net.LoadFile(path); err != nil {
+		return err
+	}
+	d.mutex.Lock()
+	d.net = net
+	d.mutex.Unlock()
+	return nil
+}
+
+// Loaded returns whether a network is loaded.
+func (d *digitReader) Loaded() bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.net != nil
+}
+
+// Read returns the digits of a crop, false if no network is loaded
+// or no characters were found.
+func (d *digitReader) Read(crop image.Image) (ocrResult, bool) {
+	d.mutex.RLock()
+	net := d.net
+	d.mutex.RUnlock()
+	if net == nil {
+		return ocrResult{}, false
+	}
+	result := readDigits(net, crop, getOCRMinConfidence())
+	return result, len(result.Characters) > 0
+}
+
+// Classes of objects whose crops are read for printed digits.
+var textClasses = []string{classLabel, classBarcode}
+
+// Reads the digits printed on the labels and barcodes of the binary
+// frame of a frame record. Like barcodes, text is only reported on
+// its first sighting.
+func readLabels(log *logrus.Entry, fr *frameRecord, binary *frameImage, objects map[string][]image.Rectangle, frame image.Rectangle, now time.Time) []XrayEvent {
+	if len(objects[classLabel]) == 0 && len(objects[classBarcode]) == 0 {
+		return nil
+	}
+	if !globalDigitReader.Loaded() {
+		return nil
+	}
+	img, err := binary.Decode()
+	if err != nil {
+		sampledErrorIf(log, err, "Unable to decode frame for text recognition")
+		return nil
+	}
+	si, ok := img.(subImager)
+	if !ok {
+		return nil
+	}
+
+	var events []XrayEvent
+	deduper := getBarcodeDeduperForClient(fr.ClientID)
+	for _, class := range textClasses {
+		for _, rect := range objects[class] {
+			r := scaleRect(rect, frame, img.Bounds())
+			if r.Dy() < ocrMinHeight {
+				continue
+			}
+			result, ok := globalDigitReader.Read(si.SubImage(r))
+			if !ok || strings.Trim(result.Text, "?") == "" {
+				continue
+			}
+			first := deduper.FirstSightings([]barcodeStruct{{Value: result.Text, Format: "text"}}, now)
+			if len(first) == 0 {
+				continue
+			}
+			log.WithField("text", result.Text).Info("Text recognized")
+			events = append(events, XrayEvent{
+				Type:  eventTextRecognized,
+				Class: class,
+				Text: &XrayText{
+					Value:      result.Text,
+					Confidence: result.Confidence,
+					Rect:       rect,
+				},
+			})
+		}
+	}
+	return events
+}
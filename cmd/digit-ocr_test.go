@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Returns the ink of the centre of a 16x16 digit sample on a white
+// background, neighbouring samples bleed into the cell borders.
+func digitSample(img *image.Gray, cell image.Rectangle) *image.Gray {
+	sample := image.NewGray(image.Rect(0, 0, 16, 16))
+	draw.Draw(sample, sample.Bounds(), image.White, image.ZP, draw.Src)
+	for y := 2; y < 15; y++ {
+		for x := 4; x < 12; x++ {
+			if img.GrayAt(cell.Min.X+x, cell.Min.Y+y).Y < 128 {
+				sample.SetGray(x, y, color.Gray{})
+			}
+		}
+	}
+	return sample
+}
+
+// Returns a label printing the given digits, every digit is the
+// first sample of the Simd digit images recognized by the network.
+func digitLabel(t *testing.T, net *neuralNetwork, digits string, inverted bool) *image.Gray {
+	const margin = 8
+	label := image.NewGray(image.Rect(0, 0, 2*margin+len(digits)*16, 16+2*margin))
+	draw.Draw(label, label.Bounds(), image.White, image.ZP, draw.Src)
+
+	for i, d := range digits {
+		img, err := readPGM(filepath.Join("..", "contrib", "Simd", "data", "image", "digit", fmt.Sprintf("%c.pgm", d)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var sample *image.Gray
+		for y := 0; sample == nil && y+16 <= img.Bounds().Dy(); y += 16 {
+			for x := 0; x+16 <= img.Bounds().Dx(); x += 16 {
+				s := digitSample(img, image.Rect(x, y, x+16, y+16))
+				if l, score := net.Classify(s, true); l == int(d-'0') && score > 0.9 {
+					sample = s
+					break
+				}
+			}
+		}
+		if sample == nil {
+			t.Fatalf("No sample of digit %c recognized", d)
+		}
+		at := image.Pt(margin+i*16, margin)
+		draw.Draw(label, sample.Bounds().Add(at), sample, image.ZP, draw.Src)
+	}
+	if inverted {
+		for i := range label.Pix {
+			label.Pix[i] = 255 - label.Pix[i]
+		}
+	}
+	return label
+}
+
+func TestReadDigits(t *testing.T) {
+	net := newDigitNetwork()
+	if err := net.LoadFile(filepath.Join("..", "contrib", "Simd", "data", "network", "digit.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		digits   string
+		inverted bool
+	}{
+		{"0123456789", false},
+		{"4071", true},
+	}
+	for i, testCase := range testCases {
+		result := readDigits(net, digitLabel(t, net, testCase.digits, testCase.inverted), 0.5)
+		if result.Text != testCase.digits {
+			t.Errorf("Test %d: expected %q, got %q", i+1, testCase.digits, result.Text)
+		}
+		if len(result.Characters) != len(testCase.digits) {
+			continue
+		}
+		if result.Characters[0].Rect.Min.X < 8 || result.Characters[0].Rect.Min.Y < 8 {
+			t.Errorf("Test %d: unexpected character bounds %v", i+1, result.Characters[0].Rect)
+		}
+	}
+
+	// Blank crops have no characters.
+	blank := image.NewGray(image.Rect(0, 0, 64, 32))
+	draw.Draw(blank, blank.Bounds(), image.White, image.ZP, draw.Src)
+	if result := readDigits(net, blank, 0.5); len(result.Characters) != 0 {
+		t.Errorf("Expected no characters, got %q", result.Text)
+	}
+}
+
+func TestDigitReaderWithoutNetwork(t *testing.T) {
+	if _, ok := (&digitReader{}).Read(image.NewGray(image.Rect(0, 0, 16, 16))); ok {
+		t.Fatal("Expected no result without a network")
+	}
+
+	// Frames are not decoded for labels which cannot be read.
+	reader := globalDigitReader
+	defer func() { globalDigitReader = reader }()
+	globalDigitReader = &digitReader{}
+
+	binary := &frameImage{data: []byte("frame")}
+	objects := map[string][]image.Rectangle{classLabel: {image.Rect(0, 0, 100, 40)}}
+	fr := &frameRecord{ClientID: "digit-reader-without-network"}
+	events := readLabels(logrus.NewEntry(logrus.New()), fr, binary, objects, image.Rect(0, 0, 640, 480), time.Now())
+	if len(events) != 0 || binary.decoded {
+		t.Errorf("Expected no decoding without a network, got %v", events)
+	}
+}
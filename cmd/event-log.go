@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"image"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	eventMotion         = "motion"
+	eventBarcodeScanned = "barcodeScanned"
+	eventTextRecognized = "textRecognized"
+)
+
+// Maximum number of events kept in memory for the admin API.
+const maxRecentEvents = 1000
+
+// XrayEvent - represents an event detected on the frames of a client.
+type XrayEvent struct {
+	Type     string    `json:"type"`
+	Class    string    `json:"class"` // Object class which caused the event.
+	ClientID string    `json:"clientId"`
+	FrameID  int       `json:"frameId"`
+	Time     time.Time `json:"time"`
+
+	// Object name of the snapshot uploaded for this event, if any.
+	ObjectName string `json:"objectName,omitempty"`
+
+	// Object name of the manifest listing the frames captured
+	// around the event, if any.
+	Manifest string `json:"manifest,omitempty"`
+
+	// Downscaled snapshot and face crops uploaded by the server
+	// for binary frames, if any.
+	Thumbnail string     `json:"thumbnail,omitempty"`
+	Crops     []XrayCrop `json:"crops,omitempty"`
+
+	// Decoded barcode for barcode events.
+	Barcode *XrayBarcode `json:"barcode,omitempty"`
+
+	// Digits read from a label or barcode for text events.
+	Text *XrayText `json:"text,omitempty"`
+}
+
+// XrayBarcode - represents a decoded barcode.
+type XrayBarcode struct {
+	Value  string `json:"value"`
+	Format string `json:"format"`
+}
+
+// XrayText - represents digits read from a crop, unreadable digits
+// are reported as '?'.
+type XrayText struct {
+	Value      string          `json:"value"`
+	Confidence float64         `json:"confidence"`
+	Rect       image.Rectangle `json:"rect"`
+}
+
+// eventLog keeps the most recent events in memory and optionally
+// appends every event as a JSON line to a file.
+type eventLog struct {
+	mu     sync.Mutex
+	recent []XrayEvent
+	file   *os.File
+	enc    *json.Encoder
+}
+
+var globalEventLog = &eventLog{}
+
+// Open starts appending events to the file at path.
+func (l *eventLog) Open(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.file = f
+	l.enc = json.NewEncoder(f)
+	l.mu.Unlock()
+	return nil
+}
+
+// Publish records a new event.
+func (l *eventLog) Publish(event XrayEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.recent = append(l.recent, event)
+	if len(l.recent) > maxRecentEvents {
+		l.recent = l.recent[1:]
+	}
+	if l.enc != nil {
+		errorIf(l.enc.Encode(event), "Unable to write event to the event log.")
+	}
+}
+
+// Recent returns the most recent events of a client, or of all
+// the clients if clientID is empty, oldest first.
+func (l *eventLog) Recent(clientID string) []XrayEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := []XrayEvent{}
+	for _, event := range l.recent {
+		if clientID == "" || event.ClientID == clientID {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Close flushes and closes the event log file, if any.
+func (l *eventLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Sync()
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file = nil
+	l.enc = nil
+	return err
+}
@@ -0,0 +1,244 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png" // Clients may send PNG frames.
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	gocv "github.com/minio/go-cv"
+)
+
+// Longer side in pixels of face crops and frame thumbnails, larger
+// images are downscaled.
+const faceCropSize = 160
+const thumbnailSize = 320
+
+// Padding around face crops as a fraction of the face size.
+const faceCropPadding = 0.25
+
+// Quality of the encoded crops and thumbnails.
+const cropJPEGQuality = 85
+
+// XrayCrop - represents a face crop uploaded with a snapshot.
+type XrayCrop struct {
+	FaceID     string          `json:"faceId"`
+	ObjectName string          `json:"objectName"`
+	Rect       image.Rectangle `json:"rect"` // In frame coordinates.
+
+	// Person recognized in the face gallery.
+	Identity *XrayIdentity `json:"identity,omitempty"`
+}
+
+// Returns the size fitting w x h within max, images are never
+// enlarged.
+func fitSize(w, h, max int) (int, int) {
+	if w <= max && h <= max {
+		return w, h
+	}
+	if w >= h {
+		return max, (h*max + w/2) / w
+	}
+	return (w*max + h/2) / h, max
+}
+
+// Downscales an image to fit within max pixels. Grayscale images
+// are halved with the 2x2 reduction while possible, the remaining
+// scaling uses bilinear interpolation.
+func downscaleImage(img image.Image, max int) image.Image {
+	src := gocv.ViewFromImage(img)
+	defer func() { src.Close() }()
+
+	for src.Format() == gocv.GRAY8 && src.Width() >= 2*max && src.Height() >= 2*max {
+		dst := gocv.NewView((src.Width()+1)/2, (src.Height()+1)/2, gocv.GRAY8)
+		gocv.ReduceGray2x2(*src, *dst)
+		src.Close()
+		src = dst
+	}
+
+	// Views from images are GRAY8 or BGRA32, which always convert.
+	w, h := fitSize(src.Width(), src.Height(), max)
+	if w == src.Width() && h == src.Height() {
+		out, _ := src.Image()
+		return out
+	}
+	dst := gocv.NewView(w, h, src.Format())
+	defer dst.Close()
+	gocv.ResizeBilinear(*src, *dst)
+	out, _ := dst.Image()
+	return out
+}
+
+// Returns the padded face rectangle in frame coordinates.
+func padRect(r image.Rectangle, padding float64) image.Rectangle {
+	dx := int(float64(r.Dx()) * padding)
+	dy := int(float64(r.Dy()) * padding)
+	return image.Rect(r.Min.X-dx, r.Min.Y-dy, r.Max.X+dx, r.Max.Y+dy)
+}
+
+// Scales a rectangle from frame coordinates into image bounds.
+func scaleRect(r, frame, bounds image.Rectangle) image.Rectangle {
+	if frame.Empty() {
+		return r.Intersect(bounds)
+	}
+	sx := float64(bounds.Dx()) / float64(frame.Dx())
+	sy := float64(bounds.Dy()) / float64(frame.Dy())
+	return image.Rect(
+		bounds.Min.X+int(float64(r.Min.X)*sx), bounds.Min.Y+int(float64(r.Min.Y)*sy),
+		bounds.Min.X+int(float64(r.Max.X)*sx), bounds.Min.Y+int(float64(r.Max.Y)*sy),
+	).Intersect(bounds)
+}
+
+// subImager is implemented by all the decoded image types.
+type subImager interface {
+	SubImage(r image.Rectangle) image.Image
+}
+
+// snapshotObject is an encoded object uploaded with a snapshot.
+type snapshotObject struct {
+	name        string
+	data        []byte
+	contentType string
+}
+
+// snapshot is a binary frame prepared for upload with its
+// thumbnail and face crops.
+type snapshot struct {
+	thumbnail string
+	crops     []XrayCrop
+	objects   []snapshotObject
+}
+
+// Returns the identities of the faces of the snapshot, faces which
+// could not be recognized are unknown.
+func (s *snapshot) identities(faces int) []XrayIdentity {
+	ids := make([]XrayIdentity, 0, faces)
+	if s != nil {
+		for _, crop := range s.crops {
+			ids = append(ids, *crop.Identity)
+		}
+	}
+	for len(ids) < faces {
+		ids = append(ids, XrayIdentity{PersonID: unknownIdentity})
+	}
+	return ids
+}
+
+// Prepares the full frame, a thumbnail and the face crops of a
+// binary frame for upload. Face rectangles are in the coordinates
+// of the frame metadata and scaled into the decoded image.
+func encodeSnapshot(objectName string, binary *frameImage, faces []faceStruct, rects []image.Rectangle, frame image.Rectangle) (*snapshot, error) {
+	img, err := binary.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	encode := func(img image.Image) ([]byte, error) {
+		var buf bytes.Buffer
+		err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: cropJPEGQuality})
+		return buf.Bytes(), err
+	}
+
+	full, contentType, err := encodeFrame(binary.data)
+	if err != nil {
+		return nil, err
+	}
+	snap := &snapshot{}
+	snap.objects = append(snap.objects, snapshotObject{objectName, full, contentType})
+
+	prefix := burstPrefix(objectName)
+	thumb, err := encode(downscaleImage(img, thumbnailSize))
+	if err != nil {
+		return nil, err
+	}
+	snap.thumbnail = prefix + "thumbnail.jpg"
+	snap.objects = append(snap.objects, snapshotObject{snap.thumbnail, thumb, "image/jpeg"})
+
+	si, ok := img.(subImager)
+	if !ok {
+		return snap, nil
+	}
+	for i, face := range faces {
+		rect := rects[i]
+		r := scaleRect(padRect(rect, faceCropPadding), frame, img.Bounds())
+		if r.Empty() {
+			continue
+		}
+		crop, err := encode(downscaleImage(si.SubImage(r), faceCropSize))
+		if err != nil {
+			return nil, err
+		}
+		faceID := face.ID
+		if faceID == "" {
+			faceID = fmt.Sprint(i + 1)
+		}
+		name := fmt.Sprintf("%sface-%s.jpg", prefix, faceID)
+		snap.objects = append(snap.objects, snapshotObject{name, crop, "image/jpeg"})
+
+		// Recognize the face against the gallery.
+		identity := XrayIdentity{PersonID: unknownIdentity}
+		if f := scaleRect(rect, frame, img.Bounds()); f.Dx() >= descriptorCellSize && f.Dy() >= descriptorCellSize {
+			identity = globalFaceGallery.Match(faceDescriptor(si.SubImage(f)), getFaceMatchThreshold())
+		}
+		snap.crops = append(snap.crops, XrayCrop{FaceID: faceID, ObjectName: name, Rect: rect, Identity: &identity})
+	}
+	return snap, nil
+}
+
+// Prepares the binary frame of a frame record as the snapshot of a
+// trigger, returns nil if no binary frame was received with it.
+func newSnapshot(log *logrus.Entry, objectName string, fr *frameRecord, binary *frameImage, frame image.Rectangle) *snapshot {
+	if binary == nil {
+		return nil
+	}
+
+	faces, err := fr.GetFaceRectangles()
+	if err != nil {
+		sampledErrorIf(log, err, "Unable to get face rectangles")
+		return nil
+	}
+
+	start := time.Now()
+	snap, err := encodeSnapshot(objectName, binary, fr.Faces, faces, frame)
+	if err != nil {
+		sampledErrorIf(log, err, "Unable to prepare snapshot crops")
+		return nil
+	}
+	log.WithField("duration", time.Since(start)).Debugf("Prepared %d face crops", len(snap.crops))
+	return snap
+}
+
+// Uploads a snapshot with its thumbnail and face crops.
+func (v *xrayHandlers) uploadSnapshot(log *logrus.Entry, snap *snapshot) {
+	v.uploadWG.Add(1)
+	go func() {
+		defer v.uploadWG.Done()
+		for _, o := range snap.objects {
+			_, err := v.minioClient.PutObject(globalMinioClntConfig.BucketName(), o.name,
+				bytes.NewReader(o.data), o.contentType)
+			entryErrorIf(log, err, "Unable to upload snapshot object %s", o.name)
+		}
+	}()
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+func TestDownscaleImage(t *testing.T) {
+	rgba := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for i := range rgba.Pix {
+		rgba.Pix[i] = 0xff
+	}
+	img := downscaleImage(rgba, thumbnailSize)
+	if b := img.Bounds(); b.Dx() != 320 || b.Dy() != 240 {
+		t.Fatalf("Expected 320x240 thumbnail, got %v", b)
+	}
+	if c := color.RGBAModel.Convert(img.At(100, 100)).(color.RGBA); c.R != 0xff || c.A != 0xff {
+		t.Errorf("Expected white pixel, got %v", c)
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, 1280, 720))
+	if b := downscaleImage(gray, thumbnailSize).Bounds(); b.Dx() != 320 || b.Dy() != 180 {
+		t.Fatalf("Expected 320x180 thumbnail, got %v", b)
+	}
+
+	// Small images are not enlarged.
+	small := image.NewGray(image.Rect(0, 0, 100, 50))
+	if b := downscaleImage(small, thumbnailSize).Bounds(); b.Dx() != 100 || b.Dy() != 50 {
+		t.Fatalf("Expected 100x50 image, got %v", b)
+	}
+}
+
+func TestPrepareSnapshot(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1280, 960)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Face metadata is reported at half the resolution of the frame.
+	frame := image.Rect(0, 0, 640, 480)
+	faces := []faceStruct{{ID: "7"}}
+	rects := []image.Rectangle{image.Rect(100, 100, 300, 300)}
+	snap, err := encodeSnapshot("snap.jpg", &frameImage{data: buf.Bytes()}, faces, rects, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumbnail, crops, objects := snap.thumbnail, snap.crops, snap.objects
+	if thumbnail != "snap/thumbnail.jpg" {
+		t.Errorf("Unexpected thumbnail %s", thumbnail)
+	}
+	if len(crops) != 1 || crops[0].ObjectName != "snap/face-7.jpg" || crops[0].Rect != rects[0] {
+		t.Fatalf("Unexpected crops %v", crops)
+	}
+	if len(objects) != 3 || objects[0].name != "snap.jpg" {
+		t.Fatalf("Expected frame, thumbnail and crop objects, got %d", len(objects))
+	}
+
+	crop, err := jpeg.Decode(bytes.NewReader(objects[2].data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := crop.Bounds(); b.Dx() != faceCropSize || b.Dy() != faceCropSize {
+		t.Errorf("Expected %dx%d crop, got %v", faceCropSize, faceCropSize, b)
+	}
+}
@@ -0,0 +1,277 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"image"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	gocv "github.com/minio/go-cv"
+)
+
+// Face descriptors are HOG direction histograms of the face scaled
+// to a fixed size gray image.
+const (
+	descriptorFaceSize     = 64
+	descriptorCellSize     = 8
+	descriptorQuantization = 16
+)
+
+// Identity of faces not matching any person in the gallery.
+const unknownIdentity = "unknown"
+
+// Returns the minimum similarity for a face to match a person in
+// the gallery, configured through the environment.
+func getFaceMatchThreshold() float64 {
+	return envFloat("FACE_MATCH_THRESHOLD", 0.85)
+}
+
+// Computes the descriptor of a face image, descriptors are
+// normalized so that their dot product is the cosine similarity.
+func faceDescriptor(face image.Image) []float32 {
+	b := face.Bounds()
+	src := gocv.NewView(b.Dx(), b.Dy(), gocv.GRAY8)
+	defer src.Close()
+	src.CopyFrom(face)
+	dst := gocv.NewView(descriptorFaceSize, descriptorFaceSize, gocv.GRAY8)
+	defer dst.Close()
+	gocv.ResizeBilinear(*src, *dst)
+
+	desc := gocv.HogDirectionHistograms(*dst, descriptorCellSize, descriptorCellSize, descriptorQuantization)
+	var norm float64
+	for _, f := range desc {
+		norm += float64(f) * float64(f)
+	}
+	if norm = math.Sqrt(norm); norm > 0 {
+		for i := range desc {
+			desc[i] = float32(float64(desc[i]) / norm)
+		}
+	}
+	return desc
+}
+
+// Returns the cosine similarity of two normalized descriptors.
+func descriptorSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	return float64(gocv.SvmSumLinear(a, b, []float32{1}, 1))
+}
+
+// Returns the face region of a crop padded by faceCropPadding.
+func unpadRect(r image.Rectangle, padding float64) image.Rectangle {
+	dx := int(float64(r.Dx()) * padding / (1 + 2*padding))
+	dy := int(float64(r.Dy()) * padding / (1 + 2*padding))
+	return image.Rect(r.Min.X+dx, r.Min.Y+dy, r.Max.X-dx, r.Max.Y-dy)
+}
+
+// XrayIdentity - represents the person recognized for a face.
+type XrayIdentity struct {
+	// Person id, unknownIdentity if no person matched.
+	PersonID string `json:"personId"`
+	Name     string `json:"name,omitempty"`
+
+	// Similarity with the closest person in the gallery.
+	Score float64 `json:"score"`
+}
+
+// galleryPerson is a person enrolled in the gallery.
+type galleryPerson struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Enrolled    time.Time   `json:"enrolled"`
+	Descriptors [][]float32 `json:"descriptors"`
+}
+
+// galleryPersonInfo is the admin API representation of a person.
+type galleryPersonInfo struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Enrolled    time.Time `json:"enrolled"`
+	Descriptors int       `json:"descriptors"`
+}
+
+// faceGallery holds the enrolled persons, persisted as JSON to a
+// local file if a path is configured.
+type faceGallery struct {
+	mu      sync.RWMutex
+	path    string
+	persons map[string]*galleryPerson
+}
+
+var globalFaceGallery = &faceGallery{persons: make(map[string]*galleryPerson)}
+
+// Open loads the gallery from path, a missing file is an empty
+// gallery. Changes are saved back to path.
+func (g *faceGallery) Open(path string) error {
+	persons := make(map[string]*galleryPerson)
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var list []*galleryPerson
+		if err = json.Unmarshal(data, &list); err != nil {
+			return err
+		}
+		for _, p := range list {
+			persons[p.ID] = p
+		}
+	}
+
+	g.mu.Lock()
+	g.path = path
+	g.persons = persons
+	g.mu.Unlock()
+	return nil
+}
+
+// Writes persons to the gallery file, the file is replaced
+// atomically. Must be called with the lock held.
+func (g *faceGallery) save(persons map[string]*galleryPerson) error {
+	if g.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(sortPersons(persons))
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(g.path), ".gallery")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), g.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Returns the persons sorted by id.
+func sortPersons(persons map[string]*galleryPerson) []*galleryPerson {
+	list := make([]*galleryPerson, 0, len(persons))
+	for _, p := range persons {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// List returns the enrolled persons.
+func (g *faceGallery) List() []galleryPersonInfo {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	infos := []galleryPersonInfo{}
+	for _, p := range sortPersons(g.persons) {
+		infos = append(infos, galleryPersonInfo{p.ID, p.Name, p.Enrolled, len(p.Descriptors)})
+	}
+	return infos
+}
+
+// Returns a copy of the persons to be changed and saved before
+// replacing the gallery, must be called with the lock held.
+func (g *faceGallery) copyPersons() map[string]*galleryPerson {
+	persons := make(map[string]*galleryPerson, len(g.persons))
+	for id, p := range g.persons {
+		persons[id] = p
+	}
+	return persons
+}
+
+// Enroll adds a face descriptor to a person, creating the person
+// if needed. The gallery is unchanged if it cannot be saved.
+func (g *faceGallery) Enroll(id, name string, desc []float32) (galleryPersonInfo, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	p := &galleryPerson{ID: id, Enrolled: time.Now().UTC()}
+	if old, ok := g.persons[id]; ok {
+		*p = *old
+	}
+	if name != "" {
+		p.Name = name
+	}
+	// Never append into the descriptors of the enrolled person.
+	n := len(p.Descriptors)
+	p.Descriptors = append(p.Descriptors[:n:n], desc)
+
+	persons := g.copyPersons()
+	persons[id] = p
+	if err := g.save(persons); err != nil {
+		return galleryPersonInfo{}, err
+	}
+	g.persons = persons
+	return galleryPersonInfo{p.ID, p.Name, p.Enrolled, len(p.Descriptors)}, nil
+}
+
+// Delete removes a person, returns false if not enrolled. The
+// person stays enrolled if the gallery cannot be saved.
+func (g *faceGallery) Delete(id string) (bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.persons[id]; !ok {
+		return false, nil
+	}
+	persons := g.copyPersons()
+	delete(persons, id)
+	if err := g.save(persons); err != nil {
+		return true, err
+	}
+	g.persons = persons
+	return true, nil
+}
+
+// Match returns the person closest to a descriptor, or the unknown
+// identity if no person is closer than threshold.
+func (g *faceGallery) Match(desc []float32, threshold float64) XrayIdentity {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	best := XrayIdentity{PersonID: unknownIdentity}
+	var bestPerson *galleryPerson
+	for _, p := range g.persons {
+		for _, d := range p.Descriptors {
+			if score := descriptorSimilarity(desc, d); score > best.Score {
+				best.Score = score
+				bestPerson = p
+			}
+		}
+	}
+	if bestPerson != nil && best.Score >= threshold {
+		best.PersonID, best.Name = bestPerson.ID, bestPerson.Name
+	}
+	return best
+}
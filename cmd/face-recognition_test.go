@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"image"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Returns a synthetic face image with stripes of the given period.
+func stripedImage(w, h, period int, vertical bool) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := y
+			if vertical {
+				p = x
+			}
+			if (p/period)%2 == 0 {
+				img.Pix[y*img.Stride+x] = 0xff
+			}
+		}
+	}
+	return img
+}
+
+func TestFaceGallery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xray-gallery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "gallery.json")
+
+	alice := faceDescriptor(stripedImage(96, 96, 6, true))
+	bob := faceDescriptor(stripedImage(96, 96, 6, false))
+	if s := descriptorSimilarity(alice, alice); math.Abs(s-1) > 1e-3 {
+		t.Fatalf("Expected similarity of 1 with itself, got %f", s)
+	}
+
+	g := &faceGallery{persons: make(map[string]*galleryPerson)}
+	if err = g.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Enroll("alice", "Alice", alice); err != nil {
+		t.Fatal(err)
+	}
+
+	if id := g.Match(alice, 0.85); id.PersonID != "alice" || id.Name != "Alice" {
+		t.Errorf("Expected alice, got %v", id)
+	}
+	if id := g.Match(bob, 0.85); id.PersonID != unknownIdentity {
+		t.Errorf("Expected unknown identity, got %v", id)
+	}
+
+	// The gallery is persisted across restarts.
+	g = &faceGallery{}
+	if err = g.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if list := g.List(); len(list) != 1 || list[0].ID != "alice" || list[0].Descriptors != 1 {
+		t.Fatalf("Expected alice in the reloaded gallery, got %v", list)
+	}
+
+	if found, err := g.Delete("alice"); err != nil || !found {
+		t.Fatalf("Expected alice to be deleted, got %v %v", found, err)
+	}
+	if found, _ := g.Delete("alice"); found {
+		t.Error("Expected alice to be gone")
+	}
+	if id := g.Match(alice, 0.85); id.PersonID != unknownIdentity {
+		t.Errorf("Expected unknown identity after delete, got %v", id)
+	}
+
+	// Changes which cannot be saved leave the gallery unchanged.
+	if _, err = g.Enroll("bob", "Bob", bob); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = g.Enroll("alice", "Alice", alice); err == nil {
+		t.Error("Expected enroll to fail without the gallery directory")
+	}
+	if _, err = g.Enroll("bob", "Bob", alice); err == nil {
+		t.Error("Expected enroll to fail without the gallery directory")
+	}
+	if found, err := g.Delete("bob"); err == nil || !found {
+		t.Errorf("Expected delete to fail without the gallery directory, got %v %v", found, err)
+	}
+	if list := g.List(); len(list) != 1 || list[0].ID != "bob" || list[0].Descriptors != 1 {
+		t.Errorf("Expected only bob in the gallery, got %v", list)
+	}
+	if id := g.Match(alice, 0.85); id.PersonID != unknownIdentity {
+		t.Errorf("Expected unknown identity after failed changes, got %v", id)
+	}
+}
@@ -0,0 +1,251 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"image"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// framingOptions controls how the target crop is computed.
+type framingOptions struct {
+	// Margin added around the union of objects, as a fraction
+	// of the union size on every side.
+	Padding float64
+
+	// Space left above the objects, as a fraction of the crop
+	// height. Ignored when RuleOfThirds is set.
+	Headroom float64
+
+	// Place the eye line of the objects on the upper third line
+	// and the objects on the vertical third closest to them.
+	RuleOfThirds bool
+
+	// Maximum zoom ratio, the crop is never smaller than the
+	// frame divided by this ratio.
+	MaxZoom float64
+
+	// Weight of the newest target when smoothing the crop between
+	// frames, 1 disables smoothing.
+	Smoothing float64
+}
+
+// Default framing options.
+const (
+	defaultFramingPadding   = 0.25
+	defaultFramingHeadroom  = 0.1
+	defaultFramingMaxZoom   = 4.0
+	defaultFramingSmoothing = 0.3
+)
+
+// Eyes are approximately at this fraction of the face height.
+const eyeLineRatio = 0.4
+
+// Returns the framing options configured through the environment.
+func getFramingOptions() framingOptions {
+	return framingOptions{
+		Padding:      envFloat("FRAMING_PADDING", defaultFramingPadding),
+		Headroom:     envFloat("FRAMING_HEADROOM", defaultFramingHeadroom),
+		RuleOfThirds: os.Getenv("FRAMING_RULE_OF_THIRDS") != "",
+		MaxZoom:      envFloat("FRAMING_MAX_ZOOM", defaultFramingMaxZoom),
+		Smoothing:    envFloat("FRAMING_SMOOTHING", defaultFramingSmoothing),
+	}
+}
+
+// Parses a float environment variable, returns def if unset or invalid.
+func envFloat(key string, def float64) float64 {
+	f, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
+// Parses an int environment variable, returns def if unset or invalid.
+func envInt(key string, def int) int {
+	i, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return i
+}
+
+// XrayFraming - represents the suggested framing of a frame
+// as a crop rectangle together with the pan, tilt and zoom
+// needed to reach it.
+type XrayFraming struct {
+	// Crop rectangle in frame pixels.
+	Crop image.Rectangle
+
+	// Horizontal and vertical offset of the crop centre from the
+	// frame centre, normalized to [-1, 1]. Positive values pan
+	// right and tilt down.
+	Pan, Tilt float64
+
+	// Zoom ratio of the frame width to the crop width.
+	Zoom float64
+}
+
+// rectF is a floating point rectangle used while computing crops.
+type rectF struct {
+	x, y, w, h float64
+}
+
+func (r rectF) rect() image.Rectangle {
+	return image.Rect(int(math.Floor(r.x)), int(math.Floor(r.y)),
+		int(math.Ceil(r.x+r.w)), int(math.Ceil(r.y+r.h)))
+}
+
+// Shifts v by the least amount so that [v, v+size] covers
+// [lo, hi] if possible and stays within [min, max].
+func fitRange(v, size, lo, hi, min, max float64) float64 {
+	if v > lo {
+		v = lo
+	}
+	if v+size < hi {
+		v = hi - size
+	}
+	return math.Max(min, math.Min(v, max-size))
+}
+
+// calculateFraming computes the crop rectangle which frames the
+// union of rects within frame, preserving the frame aspect ratio.
+// Returns false if there is nothing to frame.
+func calculateFraming(rects []image.Rectangle, frame image.Rectangle, opts framingOptions) (rectF, bool) {
+	var union image.Rectangle
+	for _, rect := range rects {
+		union = union.Union(rect)
+	}
+	union = union.Intersect(frame)
+	if union.Empty() || frame.Empty() {
+		return rectF{}, false
+	}
+
+	fx, fy := float64(frame.Min.X), float64(frame.Min.Y)
+	fw, fh := float64(frame.Dx()), float64(frame.Dy())
+	aspect := fw / fh
+
+	ux, uy := float64(union.Min.X), float64(union.Min.Y)
+	uw, uh := float64(union.Dx()), float64(union.Dy())
+
+	// Size the crop to fit the padded union with the frame aspect ratio.
+	pw, ph := uw*(1+2*opts.Padding), uh*(1+2*opts.Padding)
+	cw := math.Max(pw, ph*aspect)
+	if opts.MaxZoom >= 1 {
+		cw = math.Max(cw, fw/opts.MaxZoom)
+	}
+	cw = math.Min(cw, fw)
+	ch := cw / aspect
+
+	// Centre on the union by default.
+	cx := ux + uw/2 - cw/2
+	cy := uy + uh/2 - ch/2
+
+	if opts.RuleOfThirds {
+		eyeLine := uy + uh*eyeLineRatio
+		cy = eyeLine - ch/3
+		if ux+uw/2 < fx+fw/2 {
+			cx = ux + uw/2 - cw/3
+		} else {
+			cx = ux + uw/2 - 2*cw/3
+		}
+	} else if opts.Headroom > 0 {
+		cy = uy - opts.Headroom*ch
+	}
+
+	// Keep the union inside the crop and the crop inside the frame.
+	cx = fitRange(cx, cw, ux, ux+uw, fx, fx+fw)
+	cy = fitRange(cy, ch, uy, uy+uh, fy, fy+fh)
+
+	return rectF{cx, cy, cw, ch}, true
+}
+
+// Converts a crop into the framing returned to the clients.
+func newXrayFraming(crop rectF, frame image.Rectangle) *XrayFraming {
+	fw, fh := float64(frame.Dx()), float64(frame.Dy())
+	fcx, fcy := float64(frame.Min.X)+fw/2, float64(frame.Min.Y)+fh/2
+	return &XrayFraming{
+		Crop: crop.rect(),
+		Pan:  (crop.x + crop.w/2 - fcx) / (fw / 2),
+		Tilt: (crop.y + crop.h/2 - fcy) / (fh / 2),
+		Zoom: fw / crop.w,
+	}
+}
+
+// autoFramer smooths the crop of a client between frames so
+// that cameras re-frame gradually instead of jumping.
+type autoFramer struct {
+	mutex sync.Mutex
+	opts  framingOptions
+	crop  rectF
+	valid bool
+}
+
+// Frame computes the smoothed framing for the rects, returns
+// nil if there is nothing to frame.
+func (af *autoFramer) Frame(rects []image.Rectangle, frame image.Rectangle) *XrayFraming {
+	af.mutex.Lock()
+	defer af.mutex.Unlock()
+
+	target, ok := calculateFraming(rects, frame, af.opts)
+	if !ok {
+		af.valid = false
+		return nil
+	}
+
+	alpha := af.opts.Smoothing
+	if !af.valid || alpha <= 0 || alpha >= 1 {
+		af.crop = target
+	} else {
+		af.crop = rectF{
+			x: af.crop.x + alpha*(target.x-af.crop.x),
+			y: af.crop.y + alpha*(target.y-af.crop.y),
+			w: af.crop.w + alpha*(target.w-af.crop.w),
+			h: af.crop.h + alpha*(target.h-af.crop.h),
+		}
+	}
+	af.valid = true
+
+	return newXrayFraming(af.crop, frame)
+}
+
+var (
+	framerMu  sync.Mutex
+	framerMap = make(map[string]*autoFramer)
+)
+
+func getFramerForClient(clientID string) *autoFramer {
+	framerMu.Lock()
+	defer framerMu.Unlock()
+	if _, ok := framerMap[clientID]; !ok {
+		framerMap[clientID] = &autoFramer{opts: getFramingOptions()}
+	}
+	return framerMap[clientID]
+}
+
+// Drops the framing state for a client.
+func resetFramerForClient(clientID string) {
+	framerMu.Lock()
+	delete(framerMap, clientID)
+	framerMu.Unlock()
+}
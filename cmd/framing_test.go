@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestCalculateFraming(t *testing.T) {
+	frame := image.Rect(0, 0, 960, 720)
+	opts := framingOptions{Padding: defaultFramingPadding, MaxZoom: defaultFramingMaxZoom}
+
+	testCases := []struct {
+		faces        []image.Rectangle
+		thirds       bool
+		panSign      float64
+		expectedZoom float64
+	}{
+		// Centred face, crop limited by maximum zoom.
+		{[]image.Rectangle{image.Rect(455, 335, 505, 385)}, false, 0, defaultFramingMaxZoom},
+		// Two faces on the right of the frame.
+		{[]image.Rectangle{image.Rect(600, 300, 700, 400), image.Rect(750, 300, 850, 400)}, false, 1, 0},
+		// Face on the left of the frame.
+		{[]image.Rectangle{image.Rect(100, 300, 200, 400)}, false, -1, 0},
+		// Face on the left of the frame placed on the left third.
+		{[]image.Rectangle{image.Rect(100, 300, 200, 400)}, true, -1, 0},
+	}
+
+	for i, testCase := range testCases {
+		opts.RuleOfThirds = testCase.thirds
+		crop, ok := calculateFraming(testCase.faces, frame, opts)
+		if !ok {
+			t.Fatalf("Test %d: expected framing", i+1)
+		}
+		framing := newXrayFraming(crop, frame)
+
+		if !framing.Crop.In(frame) {
+			t.Errorf("Test %d: crop %v is outside of frame %v", i+1, framing.Crop, frame)
+		}
+		for _, face := range testCase.faces {
+			if !face.In(framing.Crop) {
+				t.Errorf("Test %d: face %v is outside of crop %v", i+1, face, framing.Crop)
+			}
+		}
+		if testCase.panSign == 0 && math.Abs(framing.Pan) > 0.01 {
+			t.Errorf("Test %d: expected no pan, got %f", i+1, framing.Pan)
+		}
+		if testCase.panSign*framing.Pan < 0 {
+			t.Errorf("Test %d: expected pan towards %f, got %f", i+1, testCase.panSign, framing.Pan)
+		}
+		if testCase.expectedZoom != 0 && math.Abs(framing.Zoom-testCase.expectedZoom) > 0.01 {
+			t.Errorf("Test %d: expected zoom %f, got %f", i+1, testCase.expectedZoom, framing.Zoom)
+		}
+	}
+
+	if _, ok := calculateFraming(nil, frame, opts); ok {
+		t.Errorf("Expected no framing without faces")
+	}
+}
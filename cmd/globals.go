@@ -19,10 +19,17 @@
 
 package cmd
 
-import "os"
+import (
+	"os"
+	"time"
+)
 
 // Global constants for Xray.
-const minGoVersion = ">= 1.7" // Xray requires at least Go v1.7
+const minGoVersion = ">= 1.8" // Xray requires at least Go v1.8
+
+// Maximum time to wait for in-flight detections and client
+// connections to drain during a graceful shutdown.
+const globalShutdownTimeout = 30 * time.Second
 
 var (
 	globalXrayCertFile = "/etc/ssl/public.crt"
@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+
+	router "github.com/gorilla/mux"
+)
+
+const (
+	healthStatusOK   = "ok"
+	healthStatusFail = "fail"
+)
+
+// Maximum time a single readiness check is allowed to take.
+const healthCheckTimeout = 5 * time.Second
+
+// Readiness fails once the detection queues are filled beyond this
+// ratio, clients are asked to slow down beyond it.
+const maxQueueSaturation = 0.9
+
+// Readiness fails once the number of goroutines exceeds this limit.
+const maxGoroutines = 10000
+
+var errHealthCheckTimeout = errors.New("Health check timed out")
+
+// healthCheck is the status of a single readiness check.
+type healthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// healthResult is the JSON response of the health endpoints.
+type healthResult struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// Returns the cascade file used by the object detector.
+func getCascadeFile() string {
+	if os.Getenv("LBP_CASCADE") != "" {
+		return "cascade/lbp_face.xml"
+	}
+	return "cascade/haar_face_0.xml"
+}
+
+// Runs fn with a timeout, a timed out check is reported as failed.
+func runHealthCheck(fn func() error) healthCheck {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fn()
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-time.After(healthCheckTimeout):
+		err = errHealthCheckTimeout
+	}
+	if err != nil {
+		return healthCheck{Status: healthStatusFail, Error: err.Error()}
+	}
+	return healthCheck{Status: healthStatusOK}
+}
+
+// Verifies that the object storage bucket is reachable.
+func (v *xrayHandlers) checkStorage() error {
+	exists, err := v.minioClient.BucketExists(globalMinioClntConfig.BucketName())
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("Bucket %s does not exist", globalMinioClntConfig.BucketName())
+	}
+	return nil
+}
+
+// Verifies that the detector cascade is loaded.
+func checkCascade() error {
+	if !globalObjectDetector.Loaded() {
+		return fmt.Errorf("Cascade %s is not loaded", getCascadeFile())
+	}
+	return nil
+}
+
+// Verifies that the server is not saturated.
+func (v *xrayHandlers) checkSaturation() error {
+	if n := runtime.NumGoroutine(); n > maxGoroutines {
+		return fmt.Errorf("Too many goroutines %d, limit is %d", n, maxGoroutines)
+	}
+	queued, capacity := v.pool.Queued()
+	if capacity > 0 && float64(queued) > maxQueueSaturation*float64(capacity) {
+		return fmt.Errorf("Detection queue saturated %d/%d", queued, capacity)
+	}
+	return nil
+}
+
+// Writes the health result as JSON, 503 is returned if any check failed.
+func writeHealthResult(w http.ResponseWriter, result healthResult) {
+	w.Header().Set("Content-Type", "application/json")
+	if result.Status != healthStatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	errorIf(json.NewEncoder(w).Encode(result), "Unable to write health result.")
+}
+
+// LivenessHandler reports that the server is up and serving requests.
+func (v *xrayHandlers) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthResult(w, healthResult{Status: healthStatusOK})
+}
+
+// ReadinessHandler reports whether the server is able to accept clients.
+func (v *xrayHandlers) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	result := healthResult{
+		Status: healthStatusOK,
+		Checks: map[string]healthCheck{
+			"storage":    runHealthCheck(v.checkStorage),
+			"cascade":    runHealthCheck(checkCascade),
+			"saturation": runHealthCheck(v.checkSaturation),
+		},
+	}
+
+	v.RLock()
+	closing := v.closing
+	v.RUnlock()
+	if closing {
+		result.Checks["shutdown"] = healthCheck{Status: healthStatusFail, Error: "Server is shutting down"}
+	}
+
+	for _, check := range result.Checks {
+		if check.Status != healthStatusOK {
+			result.Status = healthStatusFail
+			break
+		}
+	}
+	writeHealthResult(w, result)
+}
+
+// Register health router.
+func registerHealthRouter(mux *router.Router, xray *xrayHandlers) {
+	healthRouter := mux.NewRoute().PathPrefix("/health").Subrouter()
+	healthRouter.Methods("GET").Path("/live").HandlerFunc(xray.LivenessHandler)
+	healthRouter.Methods("GET").Path("/ready").HandlerFunc(xray.ReadinessHandler)
+}
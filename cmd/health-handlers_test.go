@@ -0,0 +1,116 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	router "github.com/gorilla/mux"
+	minio "github.com/minio/minio-go"
+)
+
+// Starts an xray server whose storage answers HEAD bucket requests
+// with the given status code.
+func newHealthTestServer(t *testing.T, storageStatus int) (*httptest.Server, *xrayHandlers, func()) {
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(storageStatus)
+	}))
+	clnt, err := minio.NewWithRegion(strings.TrimPrefix(storage.URL, "http://"), "access", "secret", false, "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xray := newXRayHandlers(clnt)
+	mux := router.NewRouter()
+	registerHealthRouter(mux, xray)
+	srv := httptest.NewServer(mux)
+	return srv, xray, func() {
+		srv.Close()
+		storage.Close()
+	}
+}
+
+// Fetches a health endpoint, returns the status code and result.
+func getHealth(t *testing.T, url string) (int, healthResult) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var result healthResult
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, result
+}
+
+func TestLivenessHandler(t *testing.T) {
+	srv, _, done := newHealthTestServer(t, http.StatusNotFound)
+	defer done()
+
+	// Liveness does not depend on storage or the cascade.
+	status, result := getHealth(t, srv.URL+"/health/live")
+	if status != http.StatusOK || result.Status != healthStatusOK {
+		t.Errorf("Expected live server, got %d %+v", status, result)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	detector := globalObjectDetector
+	defer func() { globalObjectDetector = detector }()
+
+	loaded := &objectDetector{}
+	if err := loaded.Open("../cascade/haar_face_0.xml"); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		storageStatus int
+		detector      *objectDetector
+		closing       bool
+		status        int
+		failed        string
+	}{
+		{http.StatusOK, loaded, false, http.StatusOK, ""},
+		{http.StatusOK, &objectDetector{}, false, http.StatusServiceUnavailable, "cascade"},
+		{http.StatusNotFound, loaded, false, http.StatusServiceUnavailable, "storage"},
+		{http.StatusOK, loaded, true, http.StatusServiceUnavailable, "shutdown"},
+	}
+	for i, testCase := range testCases {
+		srv, xray, done := newHealthTestServer(t, testCase.storageStatus)
+		globalObjectDetector = testCase.detector
+		xray.closing = testCase.closing
+
+		status, result := getHealth(t, srv.URL+"/health/ready")
+		done()
+		if status != testCase.status {
+			t.Errorf("Test %d: expected status %d, got %d %+v", i+1, testCase.status, status, result)
+			continue
+		}
+		for name, check := range result.Checks {
+			if failed := check.Status != healthStatusOK; failed != (name == testCase.failed) {
+				t.Errorf("Test %d: unexpected %s check %+v", i+1, name, check)
+			}
+		}
+	}
+}
@@ -32,27 +32,49 @@ type frameStruct struct {
 	Height    string `json:"height"`
 	Rotation  string `json:"rotation"`
 	Timestamp string `json:"timestamp"`
+	Zoom      string `json:"zoom"`      // Current zoom ratio of the device, optional.
+	Sharpness string `json:"sharpness"` // Sharpness of the frame between 0 and 1, optional.
 }
 
 type faceStruct struct {
-	ID           string      `json:"id"`
-	EulerY       string      `json:"eulerY"`
-	EulerZ       string      `json:"eulerZ"`
-	Height       string      `json:"height"`
-	Width        string      `json:"width"`
-	LeftEyeOpen  string      `json:"leftEyeOpen"`
-	RightEyeOpen string      `json:"rightEyeOpen"`
-	Smiling      string      `json:"similing"`
-	FacePT1      pointStruct `json:"facePt1"`
-	FacePT2      pointStruct `json:"facePt2"`
+	ID                 string      `json:"id"`
+	EulerY             string      `json:"eulerY"`
+	EulerZ             string      `json:"eulerZ"`
+	Height             string      `json:"height"`
+	Width              string      `json:"width"`
+	LeftEyeOpen        string      `json:"leftEyeOpen"`
+	RightEyeOpen       string      `json:"rightEyeOpen"`
+	Smiling            string      `json:"similing"` // Misspelled by older clients.
+	SmilingProbability string      `json:"smiling"`
+	FacePT1            pointStruct `json:"facePt1"`
+	FacePT2            pointStruct `json:"facePt2"`
 }
 
 type barcodeStruct struct {
 	ID         string      `json:"id"`
+	Value      string      `json:"value"`  // Decoded value, optional.
+	Format     string      `json:"format"` // Symbology such as QR_CODE or EAN_13, optional.
 	BarcodePT1 pointStruct `json:"barcodePt1"`
 	BarcodePT2 pointStruct `json:"barcodePt2"`
 }
 
+// objectStruct is an object of any class reported by on-device
+// detectors, legacy faces and barcodes are mapped onto it.
+type objectStruct struct {
+	ID         string            `json:"id"`
+	Class      string            `json:"class"`
+	Confidence string            `json:"confidence"` // Between 0 and 1, optional.
+	PT1        pointStruct       `json:"pt1"`
+	PT2        pointStruct       `json:"pt2"`
+	Landmarks  []landmarkStruct  `json:"landmarks"`  // Optional.
+	Attributes map[string]string `json:"attributes"` // Optional.
+}
+
+type landmarkStruct struct {
+	Type string `json:"type"`
+	pointStruct
+}
+
 type pointStruct struct {
 	X string `json:"x"`
 	Y string `json:"y"`
@@ -63,6 +85,7 @@ type frameRecord struct {
 	Frame    frameStruct     `json:"frame"`
 	Faces    []faceStruct    `json:"faces"`
 	Barcodes []barcodeStruct `json:"barcodes"`
+	Objects  []objectStruct  `json:"objects"`
 }
 
 // Extracts full frame rectangle from the incoming frame record.
@@ -72,7 +95,7 @@ func (fr *frameRecord) GetFullFrameRect() (image.Rectangle, int, error) {
 		return image.Rectangle{}, 0, err
 	}
 
-	height, err := strconv.Atoi(fr.Frame.Width)
+	height, err := strconv.Atoi(fr.Frame.Height)
 	if err != nil {
 		return image.Rectangle{}, 0, err
 	}
@@ -85,6 +108,16 @@ func (fr *frameRecord) GetFullFrameRect() (image.Rectangle, int, error) {
 	return image.Rectangle{image.Point{}, image.Point{X: width, Y: height}}, frameID, nil
 }
 
+// Extracts the current zoom ratio of the device, returns 0 if the
+// client did not report it.
+func (fr *frameRecord) GetDeviceZoom() float64 {
+	zoom, err := strconv.ParseFloat(fr.Frame.Zoom, 64)
+	if err != nil || zoom <= 0 {
+		return 0
+	}
+	return zoom
+}
+
 // Extracts all the barcode rectangles from the incoming frame record.
 func (fr *frameRecord) GetBarcodeRectangles() ([]image.Rectangle, error) {
 	var barcodes []image.Rectangle
@@ -113,6 +146,39 @@ func (fr *frameRecord) GetBarcodeRectangles() ([]image.Rectangle, error) {
 	return barcodes, nil
 }
 
+// Extracts the bounding rectangle of a generic object.
+func (o objectStruct) GetRectangle() (image.Rectangle, error) {
+	x1, err := strconv.ParseFloat(o.PT1.X, 64)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	y1, err := strconv.ParseFloat(o.PT1.Y, 64)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	x2, err := strconv.ParseFloat(o.PT2.X, 64)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	y2, err := strconv.ParseFloat(o.PT2.Y, 64)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+	return image.Rectangle{
+		image.Point{X: int(x1), Y: int(y1)}, image.Point{X: int(x2), Y: int(y2)},
+	}, nil
+}
+
+// Extracts the confidence of a generic object, objects without
+// confidence are assumed to be certain.
+func (o objectStruct) GetConfidence() float64 {
+	confidence, err := strconv.ParseFloat(o.Confidence, 64)
+	if err != nil {
+		return 1
+	}
+	return confidence
+}
+
 // Extracts all the face rectangles from the incoming frame record.
 func (fr *frameRecord) GetFaceRectangles() ([]image.Rectangle, error) {
 	var faces []image.Rectangle
@@ -145,10 +211,20 @@ func (fr *frameRecord) GetFaceRectangles() ([]image.Rectangle, error) {
 // additional methods for calculating threshold factors.
 type Rectangle image.Rectangle
 
-const zoomOutBorderSize = 50
-const nozoomBorderSize = 75
+// Borders of the zoom boxes as a fraction of the smaller frame
+// dimension, so that zoom behaves the same at every resolution.
+const zoomOutBorderRatio = 0.07
+const nozoomBorderRatio = 0.1
 const zoomBoost = 5
 
+// Zoom factor returned when nothing is detected, clients zoom out.
+const zoomNothingDetected = -1
+
+// Returns the border in pixels for a border ratio of the frame.
+func borderSize(boundingBox image.Rectangle, ratio float64) int {
+	return int(ratio * float64(min(boundingBox.Dx(), boundingBox.Dy())))
+}
+
 // Algorithm used here is pretty simple union of face rectangles is fitted
 // into respectively smaller boxes, smallest box will return back the hightest
 // zoom factor
@@ -159,11 +235,11 @@ func calculateOptimalZoomFactor(rects []image.Rectangle, boundingBox image.Recta
 	}
 
 	if final.Empty() {
-		return -1 // Zoom out when nothing detected
+		return zoomNothingDetected
 	}
 
-	nozoomBox := boundingBox.Inset(zoomOutBorderSize)
-	zoomInBox1 := nozoomBox.Inset(nozoomBorderSize)
+	nozoomBox := boundingBox.Inset(borderSize(boundingBox, zoomOutBorderRatio))
+	zoomInBox1 := nozoomBox.Inset(borderSize(boundingBox, nozoomBorderRatio))
 
 	inset := 0
 	if zoomInBox1.Size().X < zoomInBox1.Size().Y {
@@ -188,6 +264,34 @@ func calculateOptimalZoomFactor(rects []image.Rectangle, boundingBox image.Recta
 	}
 }
 
+// calculateZoomRatio returns the continuous zoom ratio relative to the
+// current zoom, at which the union of rects just fits into the no zoom
+// box of a zoom centred on the frame. Ratios below 1 zoom out. Returns
+// false when nothing is detected.
+func calculateZoomRatio(rects []image.Rectangle, boundingBox image.Rectangle) (float64, bool) {
+	var final image.Rectangle
+	for _, rect := range rects {
+		final = final.Union(rect)
+	}
+
+	if final.Empty() || boundingBox.Empty() {
+		return 0, false
+	}
+
+	fw, fh := float64(boundingBox.Dx()), float64(boundingBox.Dy())
+	cx := float64(boundingBox.Min.X) + fw/2
+	cy := float64(boundingBox.Min.Y) + fh/2
+	border := zoomOutBorderRatio * math.Min(fw, fh)
+
+	// Largest distance of the union from the frame centre.
+	dx := math.Max(math.Abs(float64(final.Min.X)-cx), math.Abs(float64(final.Max.X)-cx))
+	dy := math.Max(math.Abs(float64(final.Min.Y)-cy), math.Abs(float64(final.Max.Y)-cy))
+
+	// Half extents of the no zoom box.
+	hw, hh := fw/2-border, fh/2-border
+	return math.Min(hw/dx, hh/dy), true
+}
+
 // Point represents - 2D points specified by its coordinates x and y.
 type Point image.Point
 
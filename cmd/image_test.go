@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"image"
+	"math"
 	"testing"
 )
 
@@ -51,3 +52,47 @@ func TestZoomOutSingle(t *testing.T) {
 		face = face.Inset(-10 * zoom) // shrink box
 	}
 }
+
+func TestZoomRatioResolutionIndependent(t *testing.T) {
+
+	// Same relative face position at different resolutions.
+	for _, scale := range []int{1, 2, 6} {
+		boundingBox := image.Rect(0, 0, 640*scale, 480*scale)
+		face := image.Rect(280*scale, 200*scale, 360*scale, 280*scale)
+
+		ratio, ok := calculateZoomRatio([]image.Rectangle{face}, boundingBox)
+		if !ok {
+			t.Fatalf("Expected zoom ratio for scale %d", scale)
+		}
+		// Half extents of the no zoom box are 286.4x206.4 at scale 1.
+		expected := 206.4 / 40
+		if math.Abs(ratio-expected) > 0.001 {
+			t.Errorf("Scale %d: expected zoom ratio %f, got %f", scale, expected, ratio)
+		}
+
+		zoom := calculateOptimalZoomFactor([]image.Rectangle{face}, boundingBox)
+		if zoom != 3*zoomBoost {
+			t.Errorf("Scale %d: expected zoom %d, got %d", scale, 3*zoomBoost, zoom)
+		}
+	}
+
+	if _, ok := calculateZoomRatio(nil, image.Rect(0, 0, 640, 480)); ok {
+		t.Errorf("Expected no zoom ratio without faces")
+	}
+}
+
+func TestGetFullFrameRect(t *testing.T) {
+	fr := frameRecord{Frame: frameStruct{ID: "7", Width: "1280", Height: "720"}}
+	rect, frameID, err := fr.GetFullFrameRect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rect != image.Rect(0, 0, 1280, 720) || frameID != 7 {
+		t.Errorf("Expected frame 7 of 1280x720, got %d %v", frameID, rect)
+	}
+
+	fr.Frame.Height = "tall"
+	if _, _, err = fr.GetFullFrameRect(); err == nil {
+		t.Error("Expected an invalid height to be rejected")
+	}
+}
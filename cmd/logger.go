@@ -12,7 +12,7 @@
  * but WITHOUT ANY WARRANTY; without even the implied warranty of
  * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
  * GNU Affero General Public License for more details.
-
+ *
  * You should have received a copy of the GNU Affero General Public License
  * along with this program. If not, see <http://www.gnu.org/licenses/>.
  */
@@ -21,15 +21,75 @@ package cmd
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"path"
 	"runtime"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 )
 
 var rlog = logrus.New()
 
+// Supported log formats.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Log field names carrying per client context.
+const (
+	logFieldClientID = "client_id"
+	logFieldConnID   = "conn_id"
+	logFieldFrameID  = "frame_id"
+)
+
+// logConfig represents the logger configuration.
+type logConfig struct {
+	Level      string
+	Format     string
+	File       string
+	MaxSize    int64 // Maximum size of log file in bytes before it is rotated.
+	MaxBackups int   // Maximum number of rotated log files to keep.
+}
+
+// configureLogger applies the log level, format and output.
+func configureLogger(cfg logConfig) error {
+	level, err := logrus.ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	if globalDebug {
+		level = logrus.DebugLevel
+	}
+
+	var formatter logrus.Formatter
+	switch cfg.Format {
+	case logFormatText:
+		formatter = &logrus.TextFormatter{}
+	case logFormatJSON:
+		formatter = &logrus.JSONFormatter{}
+	default:
+		return fmt.Errorf("Unknown log format %s", cfg.Format)
+	}
+
+	var out io.Writer = os.Stderr
+	if cfg.File != "" {
+		out, err = newRotatingFile(cfg.File, cfg.MaxSize, cfg.MaxBackups)
+		if err != nil {
+			return err
+		}
+	}
+
+	rlog.Level = level
+	rlog.Formatter = formatter
+	rlog.Out = out
+	return nil
+}
+
 // Get file, line, function name of the caller.
 func callerSource() string {
 	pc, file, line, success := runtime.Caller(2)
@@ -39,7 +99,7 @@ func callerSource() string {
 	}
 	file = path.Base(file)
 	name := runtime.FuncForPC(pc).Name()
-	name = strings.TrimPrefix(name, "github.com/minio/minio/cmd.")
+	name = strings.TrimPrefix(name, "github.com/minio/xray/cmd.")
 	return fmt.Sprintf("[%s:%d:%s()]", file, line, name)
 }
 
@@ -56,6 +116,20 @@ func errorIf(err error, msg string, data ...interface{}) {
 	rlog.WithFields(fields).Errorf(msg, data...)
 }
 
+// entryErrorIf is similar to errorIf but logs with the fields
+// carried by the entry, such as the client and frame ids.
+func entryErrorIf(entry *logrus.Entry, err error, msg string, data ...interface{}) {
+	if err == nil {
+		return
+	}
+	source := callerSource()
+	fields := logrus.Fields{
+		"source": source,
+		"cause":  err.Error(),
+	}
+	entry.WithFields(fields).Errorf(msg, data...)
+}
+
 // fatalIf wrapper function which takes error and prints jsonic error messages.
 func fatalIf(err error, msg string, data ...interface{}) {
 	if err == nil {
@@ -69,6 +143,101 @@ func fatalIf(err error, msg string, data ...interface{}) {
 	rlog.WithFields(fields).Fatalf(msg, data...)
 }
 
+// flushLogs commits any buffered log output to stable storage.
+func flushLogs() error {
+	f, ok := rlog.Out.(*os.File)
+	if !ok {
+		if rf, ok := rlog.Out.(*rotatingFile); ok {
+			return rf.Sync()
+		}
+		return nil
+	}
+	// Terminals and pipes do not support fsync.
+	if st, err := f.Stat(); err != nil || !st.Mode().IsRegular() {
+		return nil
+	}
+	return f.Sync()
+}
+
 func printf(msg string, data ...interface{}) {
 	rlog.Printf(msg, data...)
 }
+
+// Per frame messages are sampled, for every key the first
+// logSampleBurst messages within logSampleInterval are logged,
+// after that only every logSampleEvery-th message.
+const (
+	logSampleInterval = time.Second
+	logSampleBurst    = 5
+	logSampleEvery    = 100
+
+	// Expired samples are purged once this many keys are tracked.
+	maxLogSamples = 1024
+)
+
+type logSample struct {
+	start time.Time
+	count int
+}
+
+// logSampler rate limits high frequency log messages.
+type logSampler struct {
+	mu      sync.Mutex
+	samples map[string]*logSample
+}
+
+var globalLogSampler = &logSampler{samples: make(map[string]*logSample)}
+
+// Allow returns true if a message with the key should be logged.
+func (s *logSampler) Allow(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if len(s.samples) > maxLogSamples {
+		s.purge(now)
+	}
+
+	sample, ok := s.samples[key]
+	if !ok || now.Sub(sample.start) >= logSampleInterval {
+		sample = &logSample{start: now}
+		s.samples[key] = sample
+	}
+	sample.count++
+	if sample.count <= logSampleBurst {
+		return true
+	}
+	return (sample.count-logSampleBurst)%logSampleEvery == 0
+}
+
+// Drops all the samples whose interval has expired.
+func (s *logSampler) purge(now time.Time) {
+	for key, sample := range s.samples {
+		if now.Sub(sample.start) >= logSampleInterval {
+			delete(s.samples, key)
+		}
+	}
+}
+
+// sampledLog returns true if a per frame message logged on entry
+// should be written out, messages are grouped by connection.
+func sampledLog(entry *logrus.Entry, msg string) bool {
+	return globalLogSampler.Allow(fmt.Sprintf("%v:%s", entry.Data[logFieldConnID], msg))
+}
+
+// sampledErrorIf is similar to entryErrorIf for high rate per frame
+// errors, messages are dropped according to the log sampler.
+func sampledErrorIf(entry *logrus.Entry, err error, msg string, data ...interface{}) {
+	if err == nil {
+		return
+	}
+	if !sampledLog(entry, msg) {
+		return
+	}
+	source := callerSource()
+	fields := logrus.Fields{
+		"source": source,
+		"cause":  err.Error(),
+	}
+	entry.WithFields(fields).Errorf(msg, data...)
+}
@@ -0,0 +1,342 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	router "github.com/gorilla/mux"
+)
+
+// metric is implemented by all the collectors which can be
+// exposed in the Prometheus text exposition format.
+type metric interface {
+	metricName() string
+	writeTo(w io.Writer)
+}
+
+// Writes the HELP and TYPE header lines for a metric.
+func writeMetricHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// Formats a sample value as expected by Prometheus.
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Formats a single label pair, escaping the value.
+func formatLabel(name, value string) string {
+	return fmt.Sprintf(`{%s="%s"}`, name, labelValueReplacer.Replace(value))
+}
+
+// counter is a monotonically increasing value, optionally
+// partitioned by the values of a single label.
+type counter struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	label  string
+	values map[string]float64
+}
+
+func newCounter(name, help string) *counter {
+	return newCounterVec(name, help, "")
+}
+
+func newCounterVec(name, help, label string) *counter {
+	return &counter{
+		name:   name,
+		help:   help,
+		label:  label,
+		values: make(map[string]float64),
+	}
+}
+
+// Inc increments the counter for the given label value, label
+// value is ignored for counters without a label.
+func (c *counter) Inc(labelValue string) {
+	c.Add(labelValue, 1)
+}
+
+// Add adds v to the counter for the given label value.
+func (c *counter) Add(labelValue string, v float64) {
+	if c.label == "" {
+		labelValue = ""
+	}
+	c.mu.Lock()
+	c.values[labelValue] += v
+	c.mu.Unlock()
+}
+
+// Delete drops the series of the given label value.
+func (c *counter) Delete(labelValue string) {
+	c.mu.Lock()
+	delete(c.values, labelValue)
+	c.mu.Unlock()
+}
+
+// Value returns the current value for the given label value.
+func (c *counter) Value(labelValue string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[labelValue]
+}
+
+func (c *counter) metricName() string { return c.name }
+
+func (c *counter) writeTo(w io.Writer) {
+	writeMetricHeader(w, c.name, c.help, "counter")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.label == "" {
+		fmt.Fprintf(w, "%s %s\n", c.name, formatMetricValue(c.values[""]))
+		return
+	}
+	labelValues := make([]string, 0, len(c.values))
+	for lv := range c.values {
+		labelValues = append(labelValues, lv)
+	}
+	sort.Strings(labelValues)
+	for _, lv := range labelValues {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabel(c.label, lv), formatMetricValue(c.values[lv]))
+	}
+}
+
+// gaugeFunc is a gauge whose value is computed at scrape time.
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+func (g *gaugeFunc) metricName() string { return g.name }
+
+func (g *gaugeFunc) writeTo(w io.Writer) {
+	writeMetricHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatMetricValue(g.fn()))
+}
+
+// histogram counts observations into configurable buckets.
+type histogram struct {
+	mu      sync.Mutex
+	name    string
+	help    string
+	buckets []float64 // Upper bounds, sorted in increasing order.
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	return &histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// Observe adds a single observation to the histogram.
+func (h *histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) metricName() string { return h.name }
+
+func (h *histogram) writeTo(w io.Writer) {
+	writeMetricHeader(w, h.name, h.help, "histogram")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabel("le", formatMetricValue(bound)), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabel("le", "+Inf"), h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatMetricValue(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+// Default latency buckets in seconds.
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// Buckets for number of objects detected in a frame.
+var objectCountBuckets = []float64{0, 1, 2, 3, 5, 8, 13}
+
+// xrayMetrics holds all the metrics exposed by xray.
+type xrayMetrics struct {
+	framesReceived    *counter
+	framesDropped     *counter
+	detectionsRun     *counter
+	detectionsSkipped *counter
+	motionTriggers    *counter
+	presignFailures   *counter
+	wsWriteErrors     *counter
+	detectionLatency  *histogram
+	facesPerFrame     *histogram
+	barcodesPerFrame  *histogram
+	objectsReported   *counter
+	policySuppressed  *counter
+
+	mu      sync.Mutex
+	metrics []metric
+}
+
+func newXrayMetrics() *xrayMetrics {
+	m := &xrayMetrics{
+		framesReceived: newCounterVec("xray_frames_received_total",
+			"Total number of frames received per client.", "client"),
+		framesDropped: newCounterVec("xray_frames_dropped_total",
+			"Total number of frames dropped before detection per client.", "client"),
+		detectionsRun: newCounterVec("xray_server_detections_total",
+			"Total number of frames detected on the server per client.", "client"),
+		detectionsSkipped: newCounterVec("xray_server_detections_skipped_total",
+			"Total number of frames of static scenes not detected on the server per client.", "client"),
+		motionTriggers: newCounterVec("xray_motion_triggers_total",
+			"Total number of motion triggers per client.", "client"),
+		presignFailures: newCounter("xray_presign_failures_total",
+			"Total number of failures generating presigned upload URLs."),
+		wsWriteErrors: newCounter("xray_websocket_write_errors_total",
+			"Total number of errors writing to websocket clients."),
+		detectionLatency: newHistogram("xray_detection_duration_seconds",
+			"Time taken to process an incoming frame.", latencyBuckets),
+		facesPerFrame: newHistogram("xray_faces_per_frame",
+			"Number of faces reported per frame.", objectCountBuckets),
+		barcodesPerFrame: newHistogram("xray_barcodes_per_frame",
+			"Number of barcodes reported per frame.", objectCountBuckets),
+		objectsReported: newCounterVec("xray_objects_reported_total",
+			"Total number of generic objects reported per known class, any other class is reported as other.", "class"),
+		policySuppressed: newCounterVec("xray_policy_suppressed_total",
+			"Total number of snapshots suppressed by client policies per client.", "client"),
+	}
+	m.metrics = []metric{
+		m.framesReceived,
+		m.framesDropped,
+		m.detectionsRun,
+		m.detectionsSkipped,
+		m.motionTriggers,
+		m.presignFailures,
+		m.wsWriteErrors,
+		m.detectionLatency,
+		m.facesPerFrame,
+		m.barcodesPerFrame,
+		m.objectsReported,
+		m.policySuppressed,
+	}
+	return m
+}
+
+// Register adds an additional metric to be exposed, replacing any
+// metric registered before under the same name.
+func (m *xrayMetrics) Register(mt metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.metrics {
+		if m.metrics[i].metricName() == mt.metricName() {
+			m.metrics[i] = mt
+			return
+		}
+	}
+	m.metrics = append(m.metrics, mt)
+}
+
+// DeleteClient drops the series of all the counters partitioned by
+// client for clientID, so that rotating client ids do not grow the
+// series without bound.
+func (m *xrayMetrics) DeleteClient(clientID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, mt := range m.metrics {
+		if c, ok := mt.(*counter); ok && c.label == "client" {
+			c.Delete(clientID)
+		}
+	}
+}
+
+// ObserveDetection records time elapsed since start.
+func (m *xrayMetrics) ObserveDetection(start time.Time) {
+	m.detectionLatency.Observe(time.Since(start).Seconds())
+}
+
+func (m *xrayMetrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, mt := range m.metrics {
+		mt.writeTo(w)
+	}
+}
+
+// ServeHTTP writes all the metrics in the Prometheus text format.
+func (m *xrayMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var buffer bytes.Buffer
+	m.writeTo(&buffer)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buffer.Bytes())
+}
+
+var globalMetrics = newXrayMetrics()
+
+// Register metrics router.
+func registerMetricsRouter(mux *router.Router, xray *xrayHandlers) {
+	globalMetrics.Register(&gaugeFunc{
+		name: "xray_connected_clients",
+		help: "Number of currently connected websocket clients.",
+		fn: func() float64 {
+			xray.RLock()
+			defer xray.RUnlock()
+			return float64(len(xray.conns))
+		},
+	})
+	globalMetrics.Register(&gaugeFunc{
+		name: "xray_detection_queue_depth",
+		help: "Number of frames waiting for a detection worker.",
+		fn: func() float64 {
+			queued, _ := xray.pool.Queued()
+			return float64(queued)
+		},
+	})
+
+	mux.Methods("GET").Path("/metrics").Handler(globalMetrics)
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	m := newXrayMetrics()
+	m.framesReceived.Inc("cam-1")
+	m.framesReceived.Inc("cam-1")
+	m.framesReceived.Inc(`cam"2`)
+	m.presignFailures.Inc("")
+	m.facesPerFrame.Observe(2)
+	m.facesPerFrame.Observe(4)
+	m.Register(&gaugeFunc{name: "xray_test_gauge", help: "Test gauge.", fn: func() float64 { return 6 }})
+	m.Register(&gaugeFunc{name: "xray_test_gauge", help: "Test gauge.", fn: func() float64 { return 7 }})
+
+	var buffer bytes.Buffer
+	m.writeTo(&buffer)
+	out := buffer.String()
+
+	expected := []string{
+		"# TYPE xray_frames_received_total counter\n",
+		`xray_frames_received_total{client="cam-1"} 2` + "\n",
+		`xray_frames_received_total{client="cam\"2"} 1` + "\n",
+		"xray_presign_failures_total 1\n",
+		`xray_faces_per_frame_bucket{le="1"} 0` + "\n",
+		`xray_faces_per_frame_bucket{le="2"} 1` + "\n",
+		`xray_faces_per_frame_bucket{le="5"} 2` + "\n",
+		`xray_faces_per_frame_bucket{le="+Inf"} 2` + "\n",
+		"xray_faces_per_frame_sum 6\n",
+		"xray_faces_per_frame_count 2\n",
+		"# TYPE xray_test_gauge gauge\n",
+		"xray_test_gauge 7\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(out, e) {
+			t.Errorf("Expected %q in metrics output:\n%s", e, out)
+		}
+	}
+
+	// Registering a metric again replaces it.
+	if n := strings.Count(out, "# TYPE xray_test_gauge gauge\n"); n != 1 {
+		t.Errorf("Expected a single xray_test_gauge family, got %d", n)
+	}
+}
+
+func TestMetricsDeleteClient(t *testing.T) {
+	m := newXrayMetrics()
+	m.framesReceived.Inc("cam-1")
+	m.framesReceived.Inc("cam-2")
+	m.motionTriggers.Inc("cam-1")
+	m.objectsReported.Inc(classFace)
+
+	m.DeleteClient("cam-1")
+
+	var buffer bytes.Buffer
+	m.writeTo(&buffer)
+	out := buffer.String()
+	if strings.Contains(out, `client="cam-1"`) {
+		t.Errorf("Expected the series of cam-1 to be dropped:\n%s", out)
+	}
+	if !strings.Contains(out, `xray_frames_received_total{client="cam-2"} 1`) {
+		t.Errorf("Expected the series of cam-2 to be kept:\n%s", out)
+	}
+	if m.objectsReported.Value(classFace) != 1 {
+		t.Errorf("Expected series not partitioned by client to be kept")
+	}
+}
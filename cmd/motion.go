@@ -91,11 +91,13 @@ func XorRects(r, s image.Rectangle) []image.Rectangle {
 }
 
 type motionRecorder struct {
-	mutex              sync.Mutex
-	prevFrame          *frameRecord
-	lastFrameHasFaces  bool
-	frameMotions       []float64
-	snapshotTimestamps []time.Time
+	mutex               sync.Mutex
+	prevObjects         []image.Rectangle
+	prevFrameRect       image.Rectangle
+	hasPrevFrame        bool
+	lastFrameHasObjects bool
+	frameMotions        []float64
+	snapshotTimestamps  []time.Time
 }
 
 func findClosestRectangle(face image.Rectangle, faces []image.Rectangle) int {
@@ -128,10 +130,7 @@ func sumAreas(rects []image.Rectangle) float64 {
 	return float64(diff)
 }
 
-func analyseBetweenFrames(prev, next *frameRecord) float64 {
-
-	prevFaces, _ := prev.GetFaceRectangles()
-	nextFaces, _ := next.GetFaceRectangles()
+func analyseBetweenFrames(prevFaces, nextFaces []image.Rectangle, frame image.Rectangle) float64 {
 
 	prevLen := len(prevFaces)
 	nextLen := len(nextFaces)
@@ -159,8 +158,6 @@ func analyseBetweenFrames(prev, next *frameRecord) float64 {
 	}
 	// Do not account for effect of excess faces in previous frame (kind of a negative change)
 
-	frame, _, _ := prev.GetFullFrameRect()
-
 	// Normalize by pixels for screen size
 	return result / float64(frame.Dx()*frame.Dy())
 }
@@ -203,14 +200,22 @@ func (mr *motionRecorder) threshold() float64 {
 	return thresholdBase + thresholdBoost*float64(len(mr.snapshotTimestamps))/maxTimestamps
 }
 
+// Append records the faces of a frame.
 func (mr *motionRecorder) Append(fr *frameRecord) {
+	faces, _ := fr.GetFaceRectangles()
+	frame, _, _ := fr.GetFullFrameRect()
+	mr.AppendObjects(faces, frame)
+}
+
+// AppendObjects records the objects of a single class in a frame.
+func (mr *motionRecorder) AppendObjects(objects []image.Rectangle, frame image.Rectangle) {
 
 	mr.mutex.Lock()
 	defer mr.mutex.Unlock()
 
-	if mr.prevFrame != nil {
+	if mr.hasPrevFrame {
 
-		diff := analyseBetweenFrames(mr.prevFrame, fr)
+		diff := analyseBetweenFrames(mr.prevObjects, objects, mr.prevFrameRect)
 
 		mr.frameMotions = append(mr.frameMotions, diff)
 		if len(mr.frameMotions) > maxFrames {
@@ -218,8 +223,10 @@ func (mr *motionRecorder) Append(fr *frameRecord) {
 		}
 	}
 
-	mr.prevFrame = fr
-	mr.lastFrameHasFaces = len(fr.Faces) > 0
+	mr.prevObjects = objects
+	mr.prevFrameRect = frame
+	mr.hasPrevFrame = true
+	mr.lastFrameHasObjects = len(objects) > 0
 }
 
 func (mr *motionRecorder) DetectMotion() bool {
@@ -239,8 +246,29 @@ func (mr *motionRecorder) DetectMotion() bool {
 		if len(mr.snapshotTimestamps) > maxTimestamps {
 			mr.snapshotTimestamps = mr.snapshotTimestamps[1:]
 		}
-		return mr.lastFrameHasFaces
+		return mr.lastFrameHasObjects
 	}
 
 	return false
 }
+
+// motionHistory is a snapshot of the motion recorder state.
+type motionHistory struct {
+	FrameMotions       []float64   `json:"frameMotions"`
+	Activity           float64     `json:"activity"`
+	Threshold          float64     `json:"threshold"`
+	SnapshotTimestamps []time.Time `json:"snapshotTimestamps"`
+}
+
+func (mr *motionRecorder) History() motionHistory {
+
+	mr.mutex.Lock()
+	defer mr.mutex.Unlock()
+
+	return motionHistory{
+		FrameMotions:       append([]float64{}, mr.frameMotions...),
+		Activity:           mr.analyze(),
+		Threshold:          mr.threshold(),
+		SnapshotTimestamps: append([]time.Time{}, mr.snapshotTimestamps...),
+	}
+}
@@ -0,0 +1,352 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strconv"
+
+	gocv "github.com/minio/go-cv"
+)
+
+// Activation functions of network layers, these match the Simd
+// network implementation so trained networks load unchanged.
+type activation int
+
+const (
+	activationIdentity activation = iota
+	activationTanh
+	activationSigmoid
+	activationRelu
+	activationLeakyRelu
+)
+
+// Applies the activation in place.
+func (a activation) apply(v []float32) {
+	switch a {
+	case activationTanh:
+		gocv.NeuralRoughTanh(v, 1, v)
+	case activationSigmoid:
+		gocv.NeuralRoughSigmoid2(v, 1, v)
+	case activationRelu:
+		gocv.NeuralRelu(v, 0, v)
+	case activationLeakyRelu:
+		gocv.NeuralRelu(v, 0.01, v)
+	}
+}
+
+// networkLayer - a layer of a feed forward network.
+type networkLayer interface {
+	// Number of input and output values.
+	inputSize() int
+	outputSize() int
+
+	// Trainable parameters in the order of the network file.
+	params() [][]float32
+
+	// Computes the layer output, src holds inputSize values.
+	forward(src []float32) []float32
+}
+
+// convolutionLayer - convolves every input channel with a core per
+// pair of input and output channels.
+type convolutionLayer struct {
+	f                   activation
+	width, height       int
+	srcDepth, dstDepth  int
+	core                int
+	valid               bool
+	dstWidth, dstHeight int
+	paddedW, paddedH    int
+	weight, bias        []float32
+}
+
+// Returns a convolution layer over width x height inputs, valid
+// layers shrink their output by the core size while others pad the
+// input with zeros.
+func newConvolutionLayer(f activation, width, height, srcDepth, dstDepth, core int, valid bool) *convolutionLayer {
+	l := &convolutionLayer{
+		f: f, width: width, height: height,
+		srcDepth: srcDepth, dstDepth: dstDepth,
+		core: core, valid: valid,
+		dstWidth: width, dstHeight: height,
+		paddedW: width, paddedH: height,
+		weight: make([]float32, core*core*srcDepth*dstDepth),
+		bias:   make([]float32, dstDepth),
+	}
+	if valid {
+		l.dstWidth, l.dstHeight = width-core+1, height-core+1
+	} else {
+		l.paddedW, l.paddedH = width+core-1, height+core-1
+	}
+	return l
+}
+
+func (l *convolutionLayer) inputSize() int      { return l.width * l.height * l.srcDepth }
+func (l *convolutionLayer) outputSize() int     { return l.dstWidth * l.dstHeight * l.dstDepth }
+func (l *convolutionLayer) params() [][]float32 { return [][]float32{l.weight, l.bias} }
+
+// Returns the input with a border of zeros for non valid layers.
+func (l *convolutionLayer) pad(src []float32) []float32 {
+	if l.valid {
+		return src
+	}
+	indent := l.core / 2
+	padded := make([]float32, l.paddedW*l.paddedH*l.srcDepth)
+	for c := 0; c < l.srcDepth; c++ {
+		for y := 0; y < l.height; y++ {
+			s := src[(c*l.height+y)*l.width:]
+			d := padded[(c*l.paddedH+y+indent)*l.paddedW+indent:]
+			copy(d[:l.width], s[:l.width])
+		}
+	}
+	return padded
+}
+
+func (l *convolutionLayer) forward(src []float32) []float32 {
+	padded := l.pad(src)
+	dstArea, srcArea, coreArea := l.dstWidth*l.dstHeight, l.paddedW*l.paddedH, l.core*l.core
+	sum := make([]float32, l.outputSize())
+	for dc := 0; dc < l.dstDepth; dc++ {
+		psum := sum[dc*dstArea : (dc+1)*dstArea]
+		for sc := 0; sc < l.srcDepth; sc++ {
+			w := l.weight[(l.srcDepth*dc+sc)*coreArea:]
+			psrc := padded[sc*srcArea:]
+			switch l.core {
+			case 3:
+				gocv.NeuralAddConvolution3x3(psrc, l.paddedW, l.dstWidth, l.dstHeight, w, psum, l.dstWidth)
+			case 5:
+				gocv.NeuralAddConvolution5x5(psrc, l.paddedW, l.dstWidth, l.dstHeight, w, psum, l.dstWidth)
+			default:
+				for y := 0; y < l.dstHeight; y++ {
+					for x := 0; x < l.dstWidth; x++ {
+						var s float32
+						for wy := 0; wy < l.core; wy++ {
+							for wx := 0; wx < l.core; wx++ {
+								s += w[wy*l.core+wx] * psrc[(y+wy)*l.paddedW+x+wx]
+							}
+						}
+						psum[y*l.dstWidth+x] += s
+					}
+				}
+			}
+		}
+		for i := range psum {
+			psum[i] += l.bias[dc]
+		}
+	}
+	l.f.apply(sum)
+	return sum
+}
+
+// maxPoolingLayer - keeps the maximum of every 2x2 block of each
+// channel.
+type maxPoolingLayer struct {
+	f                    activation
+	width, height, depth int
+}
+
+// Returns a 2x2 max pooling layer, width and height must be even.
+func newMaxPoolingLayer(f activation, width, height, depth int) *maxPoolingLayer {
+	return &maxPoolingLayer{f: f, width: width, height: height, depth: depth}
+}
+
+func (l *maxPoolingLayer) inputSize() int      { return l.width * l.height * l.depth }
+func (l *maxPoolingLayer) outputSize() int     { return l.inputSize() / 4 }
+func (l *maxPoolingLayer) params() [][]float32 { return nil }
+
+func (l *maxPoolingLayer) forward(src []float32) []float32 {
+	dst := make([]float32, l.outputSize())
+	// Channels are stacked vertically, so all of them are pooled at once.
+	gocv.NeuralMax2x2(src, l.width, l.width, l.height*l.depth, dst, l.width/2)
+	l.f.apply(dst)
+	return dst
+}
+
+// fullyConnectedLayer - connects every input to every output.
+type fullyConnectedLayer struct {
+	f            activation
+	src, dst     int
+	weight, bias []float32
+}
+
+// Returns a fully connected layer, weights are stored input major
+// as in the Simd network file.
+func newFullyConnectedLayer(f activation, src, dst int) *fullyConnectedLayer {
+	return &fullyConnectedLayer{
+		f: f, src: src, dst: dst,
+		weight: make([]float32, src*dst),
+		bias:   make([]float32, dst),
+	}
+}
+
+func (l *fullyConnectedLayer) inputSize() int      { return l.src }
+func (l *fullyConnectedLayer) outputSize() int     { return l.dst }
+func (l *fullyConnectedLayer) params() [][]float32 { return [][]float32{l.weight, l.bias} }
+
+func (l *fullyConnectedLayer) forward(src []float32) []float32 {
+	sum := make([]float32, l.dst)
+	copy(sum, l.bias)
+	for j, v := range src {
+		gocv.NeuralAddVectorMultipliedByValue(l.weight[j*l.dst:(j+1)*l.dst], v, sum)
+	}
+	l.f.apply(sum)
+	return sum
+}
+
+var errNetworkShape = errors.New("layer does not match the network output")
+
+// neuralNetwork - a feed forward network classifying gray images
+// of a fixed size. Forward passes do not share state and may run
+// concurrently once the network is loaded.
+type neuralNetwork struct {
+	width, height int
+	layers        []networkLayer
+}
+
+// Returns an empty network taking width x height gray images.
+func newNeuralNetwork(width, height int) *neuralNetwork {
+	return &neuralNetwork{width: width, height: height}
+}
+
+// Returns the number of values produced by the network.
+func (n *neuralNetwork) outputSize() int {
+	if len(n.layers) == 0 {
+		return n.width * n.height
+	}
+	return n.layers[len(n.layers)-1].outputSize()
+}
+
+// Add appends a layer whose input must match the current output.
+func (n *neuralNetwork) Add(l networkLayer) error {
+	if l.inputSize() != n.outputSize() {
+		return errNetworkShape
+	}
+	n.layers = append(n.layers, l)
+	return nil
+}
+
+// Load reads the parameters of all layers from a Simd network file,
+// the weights and then the biases of each layer as whitespace
+// separated floats.
+func (n *neuralNetwork) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanWords)
+	for i, l := range n.layers {
+		for _, p := range l.params() {
+			for j := range p {
+				if !scanner.Scan() {
+					if err := scanner.Err(); err != nil {
+						return err
+					}
+					return fmt.Errorf("network file too short at layer %d", i)
+				}
+				f, err := strconv.ParseFloat(scanner.Text(), 32)
+				if err != nil {
+					return err
+				}
+				p[j] = float32(f)
+			}
+		}
+	}
+	return nil
+}
+
+// LoadFile loads the network parameters from path.
+func (n *neuralNetwork) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return n.Load(f)
+}
+
+// Forward runs the network on width*height input values.
+func (n *neuralNetwork) Forward(src []float32) ([]float32, error) {
+	if len(src) != n.width*n.height {
+		return nil, errNetworkShape
+	}
+	for _, l := range n.layers {
+		src = l.forward(src)
+	}
+	return src, nil
+}
+
+// Converts a crop into network input, scaled to the input size with
+// values between 0 and 1. Inverted inputs map dark pixels to 1.
+func (n *neuralNetwork) input(crop image.Image, inversion bool) []float32 {
+	b := crop.Bounds()
+	src := gocv.NewView(b.Dx(), b.Dy(), gocv.GRAY8)
+	defer func() { src.Close() }()
+	src.CopyFrom(crop)
+	if src.Width() != n.width || src.Height() != n.height {
+		dst := gocv.NewView(n.width, n.height, gocv.GRAY8)
+		gocv.ResizeBilinear(*src, *dst)
+		src.Close()
+		src = dst
+	}
+
+	in := make([]float32, n.width*n.height)
+	inv := 0
+	if inversion {
+		inv = 1
+	}
+	gocv.NeuralConvert(*src, in, inv)
+	return in
+}
+
+// Classify runs the network on a crop and returns the index and
+// value of the strongest output.
+func (n *neuralNetwork) Classify(crop image.Image, inversion bool) (int, float32) {
+	out, err := n.Forward(n.input(crop, inversion))
+	if err != nil || len(out) == 0 {
+		return -1, 0
+	}
+	best := 0
+	for i, v := range out {
+		if v > out[best] {
+			best = i
+		}
+	}
+	return best, out[best]
+}
+
+// Returns the network layout of the digit classifier shipped with
+// Simd in data/network/digit.txt, it classifies 16x16 dark on light
+// digits into ten sigmoid outputs.
+func newDigitNetwork() *neuralNetwork {
+	n := newNeuralNetwork(16, 16)
+	for _, l := range []networkLayer{
+		newConvolutionLayer(activationRelu, 16, 16, 1, 12, 5, true),
+		newMaxPoolingLayer(activationRelu, 12, 12, 12),
+		newConvolutionLayer(activationRelu, 6, 6, 12, 24, 3, true),
+		newFullyConnectedLayer(activationRelu, 4*4*24, 96),
+		newFullyConnectedLayer(activationSigmoid, 96, 10),
+	} {
+		fatalIf(n.Add(l), "Invalid digit network layout")
+	}
+	return n
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"fmt"
+	"image"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gocv "github.com/minio/go-cv"
+)
+
+// Reads a PGM image as a gray image.
+func readPGM(path string) (*image.Gray, error) {
+	view := &gocv.View{}
+	if err := view.Load(path); err != nil {
+		return nil, err
+	}
+	defer view.Close()
+	return view.ToGray()
+}
+
+func TestNeuralNetworkShape(t *testing.T) {
+	n := newNeuralNetwork(16, 16)
+	if err := n.Add(newFullyConnectedLayer(activationRelu, 100, 10)); err != errNetworkShape {
+		t.Fatalf("Expected %v, got %v", errNetworkShape, err)
+	}
+	if err := n.Add(newFullyConnectedLayer(activationRelu, 256, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Load(strings.NewReader("1 2 3")); err == nil {
+		t.Fatal("Expected an error loading a truncated network")
+	}
+	if _, err := n.Forward(make([]float32, 10)); err != errNetworkShape {
+		t.Fatalf("Expected %v, got %v", errNetworkShape, err)
+	}
+}
+
+func TestNeuralNetworkForward(t *testing.T) {
+	// A padded 3x3 convolution with a centre core is the identity.
+	n := newNeuralNetwork(4, 4)
+	conv := newConvolutionLayer(activationIdentity, 4, 4, 1, 1, 3, false)
+	conv.weight[4] = 1
+	conv.bias[0] = 0.5
+	if err := n.Add(conv); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Add(newMaxPoolingLayer(activationIdentity, 4, 4, 1)); err != nil {
+		t.Fatal(err)
+	}
+	src := make([]float32, 16)
+	for i := range src {
+		src[i] = float32(i)
+	}
+	out, err := n.Forward(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []float32{5.5, 7.5, 13.5, 15.5}
+	for i := range expected {
+		if out[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, out)
+		}
+	}
+}
+
+func TestDigitNetwork(t *testing.T) {
+	data := filepath.Join("..", "contrib", "Simd", "data")
+	n := newDigitNetwork()
+	if err := n.LoadFile(filepath.Join(data, "network", "digit.txt")); err != nil {
+		t.Fatal(err)
+	}
+	for digit := 0; digit < 10; digit++ {
+		img, err := readPGM(filepath.Join(data, "image", "digit", fmt.Sprintf("%d.pgm", digit)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var total, correct int
+		b := img.Bounds()
+		for y := 0; y+16 <= b.Dy(); y += 16 {
+			for x := 0; x+16 <= b.Dx(); x += 16 {
+				label, _ := n.Classify(img.SubImage(image.Rect(x, y, x+16, y+16)), true)
+				if label == digit {
+					correct++
+				}
+				total++
+			}
+		}
+		if float64(correct) < 0.9*float64(total) {
+			t.Errorf("Digit %d: only %d of %d samples classified correctly", digit, correct, total)
+		}
+	}
+}
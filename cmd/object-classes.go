@@ -0,0 +1,266 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"image"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Object classes with dedicated handling, clients may report
+// objects of any other class through the generic objects array.
+const (
+	classFace    = "face"
+	classBarcode = "barcode"
+	classLabel   = "label"
+)
+
+// Default order in which object classes are considered for zoom
+// and framing, the first class present in a frame wins.
+var defaultZoomPriority = []string{classFace, classBarcode}
+
+// Returns the zoom priority configured through the environment
+// as a comma separated list of classes.
+func getZoomPriority() []string {
+	env := os.Getenv("ZOOM_PRIORITY")
+	if env == "" {
+		return defaultZoomPriority
+	}
+	var priority []string
+	for _, class := range strings.Split(env, ",") {
+		if class = strings.TrimSpace(class); class != "" {
+			priority = append(priority, class)
+		}
+	}
+	return priority
+}
+
+// Returns the minimum confidence for generic objects to be
+// considered, configured through the environment.
+func getMinObjectConfidence() float64 {
+	return envFloat("OBJECT_MIN_CONFIDENCE", 0)
+}
+
+// Returns the class label reported in metrics, classes without
+// dedicated handling or a zoom priority are reported as "other" to
+// bound the number of series.
+func metricClass(class string) string {
+	switch class {
+	case classFace, classBarcode, classLabel:
+		return class
+	}
+	for _, c := range getZoomPriority() {
+		if c == class {
+			return class
+		}
+	}
+	return "other"
+}
+
+// Returns the classes present in the frame sorted by name.
+func sortedClasses(objects map[string][]image.Rectangle) []string {
+	classes := make([]string, 0, len(objects))
+	for class := range objects {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+// Returns the objects of the highest priority class present in
+// the frame, used to compute zoom and framing. Classes missing
+// from the priority list come last, in name order.
+func selectZoomObjects(objects map[string][]image.Rectangle, priority []string) []image.Rectangle {
+	for _, class := range priority {
+		if rects := objects[class]; len(rects) > 0 {
+			return rects
+		}
+	}
+	for _, class := range sortedClasses(objects) {
+		if rects := objects[class]; len(rects) > 0 {
+			return rects
+		}
+	}
+	return nil
+}
+
+// classResult is the outcome of analysing one class of objects.
+type classResult struct {
+	// Set if a snapshot should be taken of this frame.
+	motionDetected bool
+
+	// Events detected, client, frame, time and snapshot are
+	// filled in by the caller.
+	events []XrayEvent
+}
+
+// classAnalyzer analyses the objects of a single class in a frame.
+type classAnalyzer func(log *logrus.Entry, fr *frameRecord, class string, rects []image.Rectangle, now time.Time) classResult
+
+// Analyzers for object classes which need more than motion
+// detection.
+var classAnalyzers = map[string]classAnalyzer{
+	classBarcode: analyzeBarcodes,
+}
+
+// Returns the analyzer for a class, motion detection by default.
+func getClassAnalyzer(class string) classAnalyzer {
+	if analyze, ok := classAnalyzers[class]; ok {
+		return analyze
+	}
+	return analyzeMotion
+}
+
+// Objects trigger a snapshot when their motion between frames
+// exceeds the threshold.
+func analyzeMotion(log *logrus.Entry, fr *frameRecord, class string, rects []image.Rectangle, now time.Time) classResult {
+	frameRect, _, _ := fr.GetFullFrameRect()
+
+	// Get recorded frames.
+	mr := getRecordForClient(fr.ClientID, class)
+	mr.AppendObjects(rects, frameRect)
+
+	// Check for motion detection.
+	if !mr.DetectMotion() {
+		return classResult{}
+	}
+	log.WithField("class", class).Info("Motion detected")
+	return classResult{
+		motionDetected: true,
+		events:         []XrayEvent{{Type: eventMotion, Class: class}},
+	}
+}
+
+// Barcodes are relevant only on first sighting, repeated scans
+// of the same barcode do not trigger a snapshot.
+func analyzeBarcodes(log *logrus.Entry, fr *frameRecord, class string, rects []image.Rectangle, now time.Time) classResult {
+	newBarcodes := getBarcodeDeduperForClient(fr.ClientID).FirstSightings(fr.GetBarcodes(getMinObjectConfidence()), now)
+
+	var result classResult
+	for _, barcode := range newBarcodes {
+		log.WithField("barcode", barcode.Value).Info("Barcode scanned")
+		result.motionDetected = true
+		result.events = append(result.events, XrayEvent{
+			Type:  eventBarcodeScanned,
+			Class: class,
+			Barcode: &XrayBarcode{
+				Value:  barcode.Value,
+				Format: barcode.Format,
+			},
+		})
+	}
+	return result
+}
+
+// Returns the legacy barcodes together with the generic objects
+// of the barcode class, dropping objects below minConfidence.
+func (fr *frameRecord) GetBarcodes(minConfidence float64) []barcodeStruct {
+	barcodes := append([]barcodeStruct{}, fr.Barcodes...)
+	for _, o := range fr.Objects {
+		if o.Class != classBarcode || o.GetConfidence() < minConfidence {
+			continue
+		}
+		barcodes = append(barcodes, barcodeStruct{
+			ID:     o.ID,
+			Value:  o.Attributes["value"],
+			Format: o.Attributes["format"],
+		})
+	}
+	return barcodes
+}
+
+// Returns all the objects in the frame record, legacy faces and
+// barcodes are mapped onto generic objects.
+func (fr *frameRecord) GetObjects() []objectStruct {
+	var objects []objectStruct
+	for _, face := range fr.Faces {
+		smiling := face.SmilingProbability
+		if smiling == "" {
+			smiling = face.Smiling
+		}
+		objects = append(objects, objectStruct{
+			ID:    face.ID,
+			Class: classFace,
+			PT1:   face.FacePT1,
+			PT2:   face.FacePT2,
+			Attributes: map[string]string{
+				"eulerY":       face.EulerY,
+				"eulerZ":       face.EulerZ,
+				"leftEyeOpen":  face.LeftEyeOpen,
+				"rightEyeOpen": face.RightEyeOpen,
+				"smiling":      smiling,
+			},
+		})
+	}
+	for _, barcode := range fr.Barcodes {
+		objects = append(objects, objectStruct{
+			ID:    barcode.ID,
+			Class: classBarcode,
+			PT1:   barcode.BarcodePT1,
+			PT2:   barcode.BarcodePT2,
+			Attributes: map[string]string{
+				"value":  barcode.Value,
+				"format": barcode.Format,
+			},
+		})
+	}
+	return append(objects, fr.Objects...)
+}
+
+// Extracts the rectangles of all the object classes present in the
+// frame record, dropping generic objects below minConfidence. A
+// legacy class is present when its field was sent, even if no
+// objects of that class were detected.
+func (fr *frameRecord) GetObjectRectangles(minConfidence float64) (map[string][]image.Rectangle, error) {
+	objects := make(map[string][]image.Rectangle)
+	if fr.Faces != nil {
+		objects[classFace] = []image.Rectangle{}
+	}
+	if fr.Barcodes != nil {
+		objects[classBarcode] = []image.Rectangle{}
+	}
+	for _, o := range fr.GetObjects() {
+		if o.Class == "" || o.GetConfidence() < minConfidence {
+			continue
+		}
+		rect, err := o.GetRectangle()
+		if err != nil {
+			return nil, err
+		}
+		objects[o.Class] = append(objects[o.Class], rect)
+	}
+	return objects, nil
+}
+
+// Marks the classes previously seen for a client as present but
+// empty, clients sending generic objects omit classes with no
+// detections in a frame.
+func addTrackedClasses(clientID string, objects map[string][]image.Rectangle) {
+	for _, class := range getRecordedClassesForClient(clientID) {
+		if _, ok := objects[class]; !ok {
+			objects[class] = []image.Rectangle{}
+		}
+	}
+}
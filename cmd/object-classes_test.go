@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"encoding/json"
+	"image"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSelectZoomObjects(t *testing.T) {
+	faces := []image.Rectangle{image.Rect(10, 10, 50, 50)}
+	barcodes := []image.Rectangle{image.Rect(100, 100, 200, 150)}
+
+	testCases := []struct {
+		objects  map[string][]image.Rectangle
+		priority []string
+		expected []image.Rectangle
+	}{
+		{map[string][]image.Rectangle{classFace: faces, classBarcode: barcodes}, defaultZoomPriority, faces},
+		{map[string][]image.Rectangle{classFace: faces, classBarcode: barcodes}, []string{classBarcode, classFace}, barcodes},
+		// An empty class falls through to the next one.
+		{map[string][]image.Rectangle{classFace: {}, classBarcode: barcodes}, defaultZoomPriority, barcodes},
+		// Classes missing from the priority list come last.
+		{map[string][]image.Rectangle{classFace: faces}, []string{classBarcode}, faces},
+		{map[string][]image.Rectangle{classFace: {}}, defaultZoomPriority, nil},
+	}
+
+	for i, testCase := range testCases {
+		rects := selectZoomObjects(testCase.objects, testCase.priority)
+		if !reflect.DeepEqual(rects, testCase.expected) {
+			t.Errorf("Test %d: expected %v, got %v", i+1, testCase.expected, rects)
+		}
+	}
+}
+
+func TestGetObjectRectangles(t *testing.T) {
+	data := `{"client_uuid": "c1", "frame": {"id": "1", "width": "640", "height": "480"},
+		"faces": [],
+		"barcodes": [{"id": "1", "value": "4006381333931", "format": "EAN_13",
+			"barcodePt1": {"x": "10", "y": "10"}, "barcodePt2": {"x": "60", "y": "30"}}],
+		"objects": [
+			{"class": "person", "confidence": "0.9", "pt1": {"x": "100", "y": "50"}, "pt2": {"x": "200", "y": "400"},
+				"landmarks": [{"type": "head", "x": "150", "y": "70"}]},
+			{"class": "vehicle", "confidence": "0.2", "pt1": {"x": "300", "y": "200"}, "pt2": {"x": "600", "y": "400"}},
+			{"class": "barcode", "pt1": {"x": "400", "y": "10"}, "pt2": {"x": "450", "y": "60"},
+				"attributes": {"value": "https://minio.io", "format": "QR_CODE"}}
+		]}`
+
+	var fr frameRecord
+	if err := json.Unmarshal([]byte(data), &fr); err != nil {
+		t.Fatal(err)
+	}
+	if fr.Objects[0].Landmarks[0].X != "150" {
+		t.Errorf("Expected landmark to be decoded, got %v", fr.Objects[0].Landmarks)
+	}
+
+	objects, err := fr.GetObjectRectangles(0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]image.Rectangle{
+		classFace:    {},
+		classBarcode: {image.Rect(10, 10, 60, 30), image.Rect(400, 10, 450, 60)},
+		"person":     {image.Rect(100, 50, 200, 400)},
+	}
+	if !reflect.DeepEqual(objects, expected) {
+		t.Errorf("Expected %v, got %v", expected, objects)
+	}
+
+	if barcodes := fr.GetBarcodes(0.5); len(barcodes) != 2 || barcodes[1].Value != "https://minio.io" {
+		t.Errorf("Expected legacy and generic barcodes, got %v", barcodes)
+	}
+	fr.Objects[2].Confidence = "0.3"
+	if barcodes := fr.GetBarcodes(0.5); len(barcodes) != 1 {
+		t.Errorf("Expected barcodes below the minimum confidence to be dropped, got %v", barcodes)
+	}
+}
+
+func TestMetricClass(t *testing.T) {
+	os.Setenv("ZOOM_PRIORITY", "person,face")
+	defer os.Unsetenv("ZOOM_PRIORITY")
+
+	testCases := []struct {
+		class    string
+		expected string
+	}{
+		{classFace, classFace},
+		{classBarcode, classBarcode},
+		{classLabel, classLabel},
+		{"person", "person"},
+		{"vehicle", "other"},
+		{"", "other"},
+	}
+	for i, testCase := range testCases {
+		if class := metricClass(testCase.class); class != testCase.expected {
+			t.Errorf("Test %d: expected %q, got %q", i+1, testCase.expected, class)
+		}
+	}
+}
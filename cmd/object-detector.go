@@ -0,0 +1,221 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	gocv "github.com/minio/go-cv"
+)
+
+var errDetectorNegative = errors.New("Invalid negative detector setting")
+
+// detectorConfig tunes the server side face detection of a client,
+// zero values fall back to the server defaults.
+type detectorConfig struct {
+	// Faces are detected on the server when the client reports none.
+	Enabled bool `json:"enabled"`
+
+	// Ratio between the window sizes of two consecutive scales.
+	ScaleFactor float64 `json:"scaleFactor,omitempty"`
+
+	// Smallest and largest faces detected in pixels of the frame.
+	MinSize int `json:"minSize,omitempty"`
+	MaxSize int `json:"maxSize,omitempty"`
+
+	// Minimal number of elementary detections of a face.
+	GroupSize int `json:"groupSize,omitempty"`
+}
+
+// Validates the detector configuration.
+func (c detectorConfig) validate() error {
+	if c.ScaleFactor != 0 && c.ScaleFactor <= 1 {
+		return fmt.Errorf("Invalid scale factor %v, must be above 1", c.ScaleFactor)
+	}
+	if c.MinSize < 0 || c.MaxSize < 0 || c.GroupSize < 0 {
+		return errDetectorNegative
+	}
+	if c.MaxSize != 0 && c.MaxSize < c.MinSize {
+		return fmt.Errorf("Invalid max size %d, smaller than min size %d", c.MaxSize, c.MinSize)
+	}
+	return nil
+}
+
+// Returns the detection options of the configuration.
+func (c detectorConfig) options() gocv.DetectorOptions {
+	opts := gocv.DefaultDetectorOptions
+	opts.ScaleFactor = envFloat("DETECT_SCALE_FACTOR", opts.ScaleFactor)
+	opts.GroupSizeMin = envInt("DETECT_GROUP_SIZE", opts.GroupSizeMin)
+	minSize := envInt("DETECT_MIN_SIZE", 0)
+	maxSize := envInt("DETECT_MAX_SIZE", 0)
+	if c.ScaleFactor != 0 {
+		opts.ScaleFactor = c.ScaleFactor
+	}
+	if c.GroupSize != 0 {
+		opts.GroupSizeMin = c.GroupSize
+	}
+	if c.MinSize != 0 {
+		minSize = c.MinSize
+	}
+	if c.MaxSize != 0 {
+		maxSize = c.MaxSize
+	}
+	opts.MinSize = image.Pt(minSize, minSize)
+	opts.MaxSize = image.Pt(maxSize, maxSize)
+	return opts
+}
+
+var (
+	detectorMu  sync.Mutex
+	detectorMap = make(map[string]detectorConfig)
+)
+
+func getDetectorForClient(clientID string) detectorConfig {
+	detectorMu.Lock()
+	defer detectorMu.Unlock()
+	return detectorMap[clientID]
+}
+
+func setDetectorForClient(clientID string, c detectorConfig) {
+	detectorMu.Lock()
+	detectorMap[clientID] = c
+	detectorMu.Unlock()
+}
+
+// Restores the default detector configuration of a client.
+func resetDetectorForClient(clientID string) {
+	detectorMu.Lock()
+	delete(detectorMap, clientID)
+	detectorMu.Unlock()
+}
+
+// objectDetector runs the face cascade on the server.
+type objectDetector struct {
+	mutex    sync.RWMutex
+	detector *gocv.Detector
+}
+
+var globalObjectDetector = &objectDetector{}
+
+// Open loads the cascade at path.
+func (d *objectDetector) Open(path string) error {
+	detector, err := gocv.LoadDetector(path)
+	if err != nil {
+		return err
+	}
+	d.mutex.Lock()
+	d.detector = detector
+	d.mutex.Unlock()
+	return nil
+}
+
+// Loaded returns whether a cascade is loaded.
+func (d *objectDetector) Loaded() bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	return d.detector != nil
+}
+
+// Detect returns the objects of a view, false if no cascade is
+// loaded.
+func (d *objectDetector) Detect(view *gocv.View, opts gocv.DetectorOptions) ([]gocv.Object, bool, error) {
+	d.mutex.RLock()
+	detector := d.detector
+	d.mutex.RUnlock()
+	if detector == nil {
+		return nil, false, nil
+	}
+	objects, err := detector.Detect(*view, opts)
+	return objects, true, err
+}
+
+// Detects the faces of the binary frame of a frame record for
+// clients with server side detection enabled which report no faces,
+// returns the faces in frame coordinates. Frames of static scenes
+// are skipped and the faces of the last detection reused.
+func detectFaces(log *logrus.Entry, fr *frameRecord, binary *frameImage, frame image.Rectangle, now time.Time) ([]image.Rectangle, bool) {
+	config := getDetectorForClient(fr.ClientID)
+	if !config.Enabled || len(fr.Faces) > 0 {
+		return nil, false
+	}
+	img, err := binary.Decode()
+	if err != nil {
+		sampledErrorIf(log, err, "Unable to decode frame for face detection")
+		return nil, false
+	}
+	if img == nil {
+		return nil, false
+	}
+	view := gocv.ViewFromImage(img)
+	defer view.Close()
+
+	scheduler := getDetectionSchedulerForClient(fr.ClientID)
+	thumb := changeThumbnail(view)
+	if !scheduler.Schedule(thumb, now) {
+		thumb.Close()
+		globalMetrics.detectionsSkipped.Inc(fr.ClientID)
+		return scheduler.Tracked(), true
+	}
+
+	// Sizes are configured in frame pixels.
+	opts := config.options()
+	opts.MinSize = scaleRect(image.Rectangle{Max: opts.MinSize}, frame, img.Bounds()).Max
+	opts.MaxSize = scaleRect(image.Rectangle{Max: opts.MaxSize}, frame, img.Bounds()).Max
+	objects, ok, err := globalObjectDetector.Detect(view, opts)
+	if err != nil || !ok {
+		thumb.Close()
+		sampledErrorIf(log, err, "Unable to detect faces")
+		return nil, false
+	}
+	globalMetrics.detectionsRun.Inc(fr.ClientID)
+
+	faces := []image.Rectangle{}
+	for _, o := range objects {
+		if o.Confidence < getMinObjectConfidence() {
+			continue
+		}
+		faces = append(faces, scaleRect(o.Rect, img.Bounds(), frame))
+	}
+	scheduler.Detected(thumb, faces, now)
+	return faces, true
+}
+
+// Records the faces detected on the server as the faces of the frame
+// record, so that snapshots, client policies and best shots handle
+// them like faces reported by the client. Server detections carry
+// no tracking, faces are numbered in detection order.
+func (fr *frameRecord) setDetectedFaces(faces []image.Rectangle) {
+	fr.Faces = make([]faceStruct, len(faces))
+	for i, r := range faces {
+		fr.Faces[i] = faceStruct{
+			ID:      strconv.Itoa(i + 1),
+			Width:   strconv.Itoa(r.Dx()),
+			Height:  strconv.Itoa(r.Dy()),
+			FacePT1: pointStruct{X: strconv.Itoa(r.Min.X), Y: strconv.Itoa(r.Min.Y)},
+			FacePT2: pointStruct{X: strconv.Itoa(r.Max.X), Y: strconv.Itoa(r.Max.Y)},
+		}
+	}
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"image"
+	"testing"
+
+	gocv "github.com/minio/go-cv"
+)
+
+func TestDetectorConfig(t *testing.T) {
+	testCases := []struct {
+		config detectorConfig
+		valid  bool
+	}{
+		{detectorConfig{}, true},
+		{detectorConfig{Enabled: true, ScaleFactor: 1.2, MinSize: 40, MaxSize: 200, GroupSize: 2}, true},
+		{detectorConfig{ScaleFactor: 1}, false},
+		{detectorConfig{MinSize: -1}, false},
+		{detectorConfig{MinSize: 100, MaxSize: 50}, false},
+	}
+	for i, testCase := range testCases {
+		if err := testCase.config.validate(); (err == nil) != testCase.valid {
+			t.Errorf("Test %d: expected valid %v, got %v", i+1, testCase.valid, err)
+		}
+	}
+
+	opts := detectorConfig{}.options()
+	if opts.ScaleFactor != gocv.DefaultDetectorOptions.ScaleFactor || opts.GroupSizeMin != gocv.DefaultDetectorOptions.GroupSizeMin {
+		t.Errorf("Expected default options, got %+v", opts)
+	}
+	opts = detectorConfig{ScaleFactor: 1.2, MinSize: 40, MaxSize: 200, GroupSize: 2}.options()
+	if opts.ScaleFactor != 1.2 || opts.GroupSizeMin != 2 || opts.MinSize != image.Pt(40, 40) || opts.MaxSize != image.Pt(200, 200) {
+		t.Errorf("Expected client options, got %+v", opts)
+	}
+}
+
+func TestObjectDetector(t *testing.T) {
+	img := &gocv.View{}
+	if err := img.Load("../contrib/Simd/data/image/face/lena.pgm"); err != nil {
+		t.Fatal(err)
+	}
+	defer img.Close()
+
+	if _, ok, _ := (&objectDetector{}).Detect(img, gocv.DefaultDetectorOptions); ok {
+		t.Fatal("Expected no detection without a cascade")
+	}
+
+	// The face of lena lies within this rectangle.
+	face := image.Rect(100, 90, 210, 200)
+	for _, cascade := range []string{"../cascade/haar_face_0.xml", "../cascade/lbp_face.xml"} {
+		d := &objectDetector{}
+		if err := d.Open(cascade); err != nil {
+			t.Fatal(err)
+		}
+		objects, ok, err := d.Detect(img, gocv.DefaultDetectorOptions)
+		if err != nil || !ok {
+			t.Fatalf("%s: unable to detect faces %v", cascade, err)
+		}
+		if len(objects) != 1 || !objects[0].Rect.In(face) {
+			t.Fatalf("%s: expected a face within %v, got %v", cascade, face, objects)
+		}
+		if objects[0].Confidence <= 0 || objects[0].Confidence >= 1 {
+			t.Errorf("%s: expected a confidence within (0, 1), got %v", cascade, objects[0].Confidence)
+		}
+
+		opts := gocv.DefaultDetectorOptions
+		opts.MinSize = image.Pt(150, 150)
+		if objects, _, _ = d.Detect(img, opts); len(objects) != 0 {
+			t.Errorf("%s: expected no face above the min size, got %v", cascade, objects)
+		}
+		opts = gocv.DefaultDetectorOptions
+		opts.MaxSize = image.Pt(40, 40)
+		if objects, _, _ = d.Detect(img, opts); len(objects) != 0 {
+			t.Errorf("%s: expected no face below the max size, got %v", cascade, objects)
+		}
+	}
+}
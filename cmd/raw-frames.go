@@ -0,0 +1,238 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	gocv "github.com/minio/go-cv"
+)
+
+// Raw frames are uncompressed YUV 4:2:0 or Bayer planes sent as
+// binary messages instead of JPEG, they start with a little endian
+// header
+//
+//	magic  [4]byte "XRAW"
+//	format uint32  Android image format or V4L2 fourcc of the planes
+//	width  uint16
+//	height uint16
+//
+// followed by the planes without padding. Like JPEG frames, raw
+// frames are only analyzed along with a JSON frame record, which
+// the client sends right after the binary frame. The record is
+// paired with the binary frame received closest to it within
+// maxFrameAge (500ms), binary frames without a record are only
+// buffered for bursts.
+const (
+	rawFrameMagic      = "XRAW"
+	rawFrameHeaderSize = 12
+)
+
+// Supported formats of raw frames, numbered as the Android image
+// formats reported in the frame metadata.
+const (
+	rawFormatNV21 = 17        // Y plane followed by interleaved V and U.
+	rawFormatI420 = 35        // Y, U and V planes.
+	rawFormatYV12 = 842094169 // Y, V and U planes.
+)
+
+// Supported Bayer formats of raw frames, numbered as the V4L2 fourcc
+// of 8 bit Bayer sensor output.
+const (
+	rawFormatBayerGRBG = 'G' | 'R'<<8 | 'B'<<16 | 'G'<<24
+	rawFormatBayerGBRG = 'G' | 'B'<<8 | 'R'<<16 | 'G'<<24
+	rawFormatBayerRGGB = 'R' | 'G'<<8 | 'G'<<16 | 'B'<<24
+	rawFormatBayerBGGR = 'B' | 'A'<<8 | '8'<<16 | '1'<<24
+)
+
+// View formats of the Bayer formats of raw frames.
+var rawBayerFormats = map[uint32]gocv.Format{
+	rawFormatBayerGRBG: gocv.BAYERGRBG,
+	rawFormatBayerGBRG: gocv.BAYERGBRG,
+	rawFormatBayerRGGB: gocv.BAYERRGGB,
+	rawFormatBayerBGGR: gocv.BAYERBGGR,
+}
+
+// Quality of the JPEG encoding of raw frames for upload.
+const rawFrameJPEGQuality = 90
+
+var (
+	errRawFrameHeader = errors.New("Invalid raw frame header")
+	errRawFrameFormat = errors.New("Unsupported raw frame format")
+	errRawFrameSize   = errors.New("Raw frame size does not match its header")
+)
+
+func init() {
+	image.RegisterFormat("xraw", rawFrameMagic, decodeRawFrame, decodeRawFrameConfig)
+}
+
+// rawFrameHeader describes the planes of a raw frame.
+type rawFrameHeader struct {
+	Format        uint32
+	Width, Height int
+}
+
+// Returns true if data holds a raw frame rather than an encoded image.
+func isRawFrame(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(rawFrameMagic))
+}
+
+// Parses the header of a raw frame, planes must have even sizes.
+func parseRawFrameHeader(header []byte) (rawFrameHeader, error) {
+	if len(header) < rawFrameHeaderSize || !isRawFrame(header) {
+		return rawFrameHeader{}, errRawFrameHeader
+	}
+	h := rawFrameHeader{
+		Format: binary.LittleEndian.Uint32(header[4:8]),
+		Width:  int(binary.LittleEndian.Uint16(header[8:10])),
+		Height: int(binary.LittleEndian.Uint16(header[10:12])),
+	}
+	if h.Width == 0 || h.Height == 0 || h.Width%2 != 0 || h.Height%2 != 0 {
+		return h, errRawFrameHeader
+	}
+	switch h.Format {
+	case rawFormatNV21, rawFormatI420, rawFormatYV12:
+	default:
+		if _, ok := rawBayerFormats[h.Format]; !ok {
+			return h, errRawFrameFormat
+		}
+	}
+	return h, nil
+}
+
+// Size of the planes following the header.
+func (h rawFrameHeader) planesSize() int {
+	if _, ok := rawBayerFormats[h.Format]; ok {
+		return h.Width * h.Height
+	}
+	return h.Width * h.Height * 3 / 2
+}
+
+// Validates a raw frame received from a client.
+func validateRawFrame(data []byte) error {
+	h, err := parseRawFrameHeader(data)
+	if err != nil {
+		return err
+	}
+	if len(data) != rawFrameHeaderSize+h.planesSize() {
+		return errRawFrameSize
+	}
+	return nil
+}
+
+// Copies a plane without padding into a view, returns the rest of src.
+func copyPlane(dst *gocv.View, src []byte) []byte {
+	row := dst.Width() * gocv.PixelSize(dst.Format())
+	pix, stride := dst.Pix(), dst.Stride()
+	for y := 0; y < dst.Height(); y++ {
+		copy(pix[y*stride:y*stride+row], src[y*row:])
+	}
+	return src[dst.Height()*row:]
+}
+
+// Converts a raw frame into a BGRA32 view, Bayer frames are
+// demosaiced and YUV frames converted through Simd.
+func rawFrameToView(data []byte) (*gocv.View, error) {
+	if err := validateRawFrame(data); err != nil {
+		return nil, err
+	}
+	h, _ := parseRawFrameHeader(data)
+	planes := data[rawFrameHeaderSize:]
+	bgra := gocv.NewView(h.Width, h.Height, gocv.BGRA32)
+
+	if format, ok := rawBayerFormats[h.Format]; ok {
+		bayer := gocv.NewView(h.Width, h.Height, format)
+		defer bayer.Close()
+		copyPlane(bayer, planes)
+		gocv.BayerToBgra(*bayer, *bgra, 0xff)
+		return bgra, nil
+	}
+
+	y := gocv.NewView(h.Width, h.Height, gocv.GRAY8)
+	defer y.Close()
+	u := gocv.NewView(h.Width/2, h.Height/2, gocv.GRAY8)
+	defer u.Close()
+	v := gocv.NewView(h.Width/2, h.Height/2, gocv.GRAY8)
+	defer v.Close()
+
+	planes = copyPlane(y, planes)
+	switch h.Format {
+	case rawFormatNV21:
+		vu := gocv.NewView(h.Width/2, h.Height/2, gocv.UV16)
+		defer vu.Close()
+		copyPlane(vu, planes)
+		gocv.DeinterleaveUv(*vu, *v, *u)
+	case rawFormatI420:
+		copyPlane(v, copyPlane(u, planes))
+	case rawFormatYV12:
+		copyPlane(u, copyPlane(v, planes))
+	}
+	gocv.Yuv420pToBgra(*y, *u, *v, *bgra, 0xff)
+	return bgra, nil
+}
+
+// Decodes a raw frame, registered with the image package so that
+// raw frames decode like any other binary frame.
+func decodeRawFrame(r io.Reader) (image.Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	view, err := rawFrameToView(data)
+	if err != nil {
+		return nil, err
+	}
+	defer view.Close()
+	return view.ToRGBA()
+}
+
+func decodeRawFrameConfig(r io.Reader) (image.Config, error) {
+	header := make([]byte, rawFrameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return image.Config{}, err
+	}
+	h, err := parseRawFrameHeader(header)
+	return image.Config{ColorModel: color.RGBAModel, Width: h.Width, Height: h.Height}, err
+}
+
+// Returns a binary frame ready for upload along with its content
+// type, raw frames are encoded as JPEG.
+func encodeFrame(data []byte) ([]byte, string, error) {
+	if !isRawFrame(data) {
+		return data, http.DetectContentType(data), nil
+	}
+	img, err := decodeRawFrame(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	var buf bytes.Buffer
+	if err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: rawFrameJPEGQuality}); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/jpeg", nil
+}
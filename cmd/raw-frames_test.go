@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+
+	gocv "github.com/minio/go-cv"
+)
+
+// Appends the pixels of a view to a plane without padding.
+func appendPlane(plane []byte, view *gocv.View) []byte {
+	row := view.Width() * gocv.PixelSize(view.Format())
+	pix, stride := view.Pix(), view.Stride()
+	for y := 0; y < view.Height(); y++ {
+		plane = append(plane, pix[y*stride:y*stride+row]...)
+	}
+	return plane
+}
+
+// Returns img as a raw frame of the given format.
+func encodeRawFrame(img image.Image, format uint32) []byte {
+	b := img.Bounds()
+	bgra := gocv.ViewFromImage(img)
+	defer bgra.Close()
+
+	data := make([]byte, rawFrameHeaderSize)
+	copy(data, rawFrameMagic)
+	binary.LittleEndian.PutUint32(data[4:], format)
+	binary.LittleEndian.PutUint16(data[8:], uint16(b.Dx()))
+	binary.LittleEndian.PutUint16(data[10:], uint16(b.Dy()))
+
+	if f, ok := rawBayerFormats[format]; ok {
+		bayer := gocv.NewView(b.Dx(), b.Dy(), f)
+		defer bayer.Close()
+		gocv.BgraToBayer(*bgra, *bayer)
+		return appendPlane(data, bayer)
+	}
+
+	y := gocv.NewView(b.Dx(), b.Dy(), gocv.GRAY8)
+	defer y.Close()
+	u := gocv.NewView(b.Dx()/2, b.Dy()/2, gocv.GRAY8)
+	defer u.Close()
+	v := gocv.NewView(b.Dx()/2, b.Dy()/2, gocv.GRAY8)
+	defer v.Close()
+	gocv.BgraToYuv420p(*bgra, *y, *u, *v)
+	data = appendPlane(data, y)
+	switch format {
+	case rawFormatNV21:
+		vu := gocv.NewView(b.Dx()/2, b.Dy()/2, gocv.UV16)
+		defer vu.Close()
+		gocv.InterleaveUv(*v, *u, *vu)
+		data = appendPlane(data, vu)
+	case rawFormatI420:
+		data = appendPlane(appendPlane(data, u), v)
+	case rawFormatYV12:
+		data = appendPlane(appendPlane(data, v), u)
+	}
+	return data
+}
+
+// Returns an image of smooth color gradients.
+func gradientImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(x * 255 / w), uint8(y * 255 / h), 128, 0xff})
+		}
+	}
+	return img
+}
+
+func TestRawFrameDecode(t *testing.T) {
+	src := gradientImage(96, 64)
+	formats := []uint32{rawFormatNV21, rawFormatI420, rawFormatYV12,
+		rawFormatBayerGRBG, rawFormatBayerGBRG, rawFormatBayerRGGB, rawFormatBayerBGGR}
+	for _, format := range formats {
+		data := encodeRawFrame(src, format)
+		img, name, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Format %d: %v", format, err)
+		}
+		if name != "xraw" || img.Bounds() != src.Bounds() {
+			t.Fatalf("Format %d: unexpected image %s %v", format, name, img.Bounds())
+		}
+		for _, pt := range []image.Point{{10, 10}, {50, 30}, {90, 60}} {
+			r0, g0, b0, _ := src.At(pt.X, pt.Y).RGBA()
+			r1, g1, b1, _ := img.At(pt.X, pt.Y).RGBA()
+			for _, d := range []int{int(r0>>8) - int(r1>>8), int(g0>>8) - int(g1>>8), int(b0>>8) - int(b1>>8)} {
+				if d < -12 || d > 12 {
+					t.Fatalf("Format %d: expected %v at %v, got %v", format, src.At(pt.X, pt.Y), pt, img.At(pt.X, pt.Y))
+				}
+			}
+		}
+
+		encoded, contentType, err := encodeFrame(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "image/jpeg" || isRawFrame(encoded) {
+			t.Fatalf("Format %d: expected a JPEG upload, got %s", format, contentType)
+		}
+	}
+}
+
+func TestRawFrameValidate(t *testing.T) {
+	valid := encodeRawFrame(gradientImage(32, 16), rawFormatNV21)
+	if err := validateRawFrame(valid); err != nil {
+		t.Fatal(err)
+	}
+	bayer := encodeRawFrame(gradientImage(32, 16), rawFormatBayerRGGB)
+	if err := validateRawFrame(bayer); err != nil {
+		t.Fatal(err)
+	}
+
+	odd := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint16(odd[8:], 31)
+	unknown := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint32(unknown[4:], 1)
+
+	testCases := []struct {
+		data []byte
+		err  error
+	}{
+		{valid[:8], errRawFrameHeader},
+		{odd, errRawFrameHeader},
+		{unknown, errRawFrameFormat},
+		{valid[:len(valid)-1], errRawFrameSize},
+		{append(bayer, 0), errRawFrameSize},
+	}
+	for i, testCase := range testCases {
+		if err := validateRawFrame(testCase.data); err != testCase.err {
+			t.Errorf("Test %d: expected %v, got %v", i+1, testCase.err, err)
+		}
+	}
+
+	// Encoded frames are uploaded unchanged.
+	jpg := []byte("\xff\xd8\xff\xe0")
+	if data, contentType, err := encodeFrame(jpg); err != nil || contentType != "image/jpeg" || !bytes.Equal(data, jpg) {
+		t.Fatalf("Expected the frame unchanged, got %s %v", contentType, err)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Signals which trigger a graceful shutdown.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// handleSignals waits for a shutdown signal, stops accepting new
+// connections, drains all the client sessions and flushes the event
+// log and logs.
+// doneCh is closed once the shutdown has completed.
+func handleSignals(httpServer *http.Server, xray *xrayHandlers, doneCh chan<- struct{}) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, shutdownSignals...)
+	sig := <-sigCh
+
+	// Restore default behavior, a second signal exits immediately.
+	signal.Stop(sigCh)
+
+	rlog.Printf("Received %s, shutting down xray server.", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), globalShutdownTimeout)
+	defer cancel()
+
+	// Stop accepting new connections, websocket connections are
+	// hijacked and therefore not waited upon by the http server.
+	errorIf(httpServer.Shutdown(ctx), "Unable to shutdown http server.")
+
+	errorIf(xray.Shutdown(ctx), "Unable to drain client sessions.")
+
+	errorIf(globalEventLog.Close(), "Unable to flush event log.")
+	errorIf(flushLogs(), "Unable to flush logs.")
+
+	close(doneCh)
+}
+
+// Shutdown waits for all in-flight detections to be answered, sends
+// a close frame to every connected client and waits for them to
+// disconnect and their bursts to be uploaded. Remaining connections
+// are forcibly closed once ctx expires.
+func (v *xrayHandlers) Shutdown(ctx context.Context) error {
+	v.Lock()
+	v.closing = true
+	v.Unlock()
+
+	// Wait for pending detections to be written back to the clients.
+	if err := waitWithContext(ctx, &v.detectWG); err != nil {
+		v.closeConns()
+		return err
+	}
+	v.pool.Close()
+
+	v.RLock()
+	for wc := range v.conns {
+		msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+		errorIf(wc.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)),
+			"Unable to send close frame to client.")
+	}
+	v.RUnlock()
+
+	// Wait for clients to acknowledge the close frame.
+	if err := waitWithContext(ctx, &v.connWG); err != nil {
+		v.closeConns()
+		return err
+	}
+
+	// Wait for the bursts of the closed connections to be uploaded.
+	return waitWithContext(ctx, &v.uploadWG)
+}
+
+// Forcibly closes all the remaining client connections.
+func (v *xrayHandlers) closeConns() {
+	v.RLock()
+	defer v.RUnlock()
+	for wc := range v.conns {
+		wc.Close()
+	}
+}
+
+// waitWithContext waits on wg until it is done or ctx expires.
+func waitWithContext(ctx context.Context, wg *sync.WaitGroup) error {
+	doneCh := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(doneCh)
+	}()
+	select {
+	case <-doneCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -22,30 +22,89 @@ package cmd
 import (
 	"bytes"
 	"encoding/json"
-	"log"
+	"sync"
 
+	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/websocket"
 )
 
 type wConn struct {
 	*websocket.Conn
+
+	// Serializes writes of data messages.
+	writeMu sync.Mutex
+
+	// Logs with the connection id.
+	log *logrus.Entry
+
+	// State of the client session.
+	session *clientSession
+
+	// Commands sent to the client awaiting acknowledgement.
+	commands *commandTracker
+
+	// Recent binary frames and pending bursts.
+	frames *frameBuffer
 }
 
-// Write client response data in json form.
-func (w *wConn) WriteMessage(mtype int, dataCh <-chan interface{}) {
+// Initializes a new websocket connection wrapper.
+func newWConn(conn *websocket.Conn, remoteAddr string) *wConn {
+	session := newClientSession(remoteAddr)
+	return &wConn{
+		Conn:     conn,
+		log:      session.newLog(),
+		session:  session,
+		commands: newCommandTracker(),
+		frames:   newFrameBuffer(getBurstOptions()),
+	}
+}
+
+// Writes the result of a frame along with the backpressure bp.
+func (w *wConn) writeResult(result XrayResult, bp *XrayBackpressure) {
+	result.Backpressure = bp
+	w.writeValue(websocket.TextMessage, result)
+}
+
+// Writes v in json form, safe to be called concurrently.
+func (w *wConn) writeValue(mtype int, v interface{}) error {
 	var buffer bytes.Buffer
-	fo := <-dataCh
 
 	enc := json.NewEncoder(&buffer)
 	enc.SetEscapeHTML(false) // Disable HTML characters from being encoded.
-	if err := enc.Encode(&fo); err != nil {
-		errorIf(err, "Unable to marshal %#v into json.", fo)
-		return
+	if err := enc.Encode(&v); err != nil {
+		entryErrorIf(w.log, err, "Unable to marshal %#v into json.", v)
+		return err
+	}
+	if rlog.Level >= logrus.DebugLevel && sampledLog(w.log, "response") {
+		w.log.Debugf("Sending response %s", buffer.Bytes())
 	}
-	if globalDebug {
-		log.Println(string(buffer.Bytes()))
+
+	// Websocket connections support only one concurrent writer.
+	w.writeMu.Lock()
+	err := w.Conn.WriteMessage(mtype, buffer.Bytes())
+	w.writeMu.Unlock()
+	if err != nil {
+		globalMetrics.wsWriteErrors.Inc("")
+		sampledErrorIf(w.log, err, "Unable to write to client.")
 	}
-	if err := w.Conn.WriteMessage(mtype, buffer.Bytes()); err != nil {
-		errorIf(err, "Unable to write to client.")
+	return err
+}
+
+// messageKeys are the top level keys of an incoming JSON message,
+// used to route the message.
+type messageKeys map[string]json.RawMessage
+
+// Decodes the top level keys of a message, messages which are not
+// JSON objects have no keys.
+func parseMessageKeys(data []byte) messageKeys {
+	var keys messageKeys
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil
 	}
+	return keys
+}
+
+func (k messageKeys) has(key string) bool {
+	_, ok := k[key]
+	return ok
 }
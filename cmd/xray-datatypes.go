@@ -19,6 +19,8 @@
 
 package cmd
 
+import "image"
+
 // XrayResult - represents zoom factor with
 // presigned post policy.
 type XrayResult struct {
@@ -28,7 +30,56 @@ type XrayResult struct {
 	// Optimal zoom factor for the camera.
 	Zoom int
 
-	// Presigned information if any for client
-	// to start upload the frames..
+	// Absolute zoom ratio for the camera, within the
+	// zoom range announced by the client.
+	ZoomRatio float64
+
+	// Presigned URL for the client to upload the frame,
+	// empty when the server uploads the binary frame itself.
 	URL string
+
+	// Best frame to upload when motion is detected, if any.
+	BestShot *XrayBestShot `json:",omitempty"`
+
+	// Frames requested around a motion trigger, if any.
+	Burst *XrayBurst `json:",omitempty"`
+
+	// Suggested framing of the detected objects, if any.
+	Framing *XrayFraming `json:",omitempty"`
+
+	// Events detected on this frame, if any.
+	Events []XrayEvent `json:",omitempty"`
+
+	// Request to reduce the frame rate, if any.
+	Backpressure *XrayBackpressure `json:",omitempty"`
+}
+
+// XrayCommand - represents a camera control command
+// pushed by the server, clients acknowledge every
+// command with its command id.
+type XrayCommand struct {
+	// Command id, unique per connection.
+	CommandID uint64 `json:"CommandId"`
+
+	// Command type, one of the camera command types.
+	Command string
+
+	// New resolution for set resolution command.
+	Width  int `json:",omitempty"`
+	Height int `json:",omitempty"`
+
+	// New frame rate for set frame rate command.
+	FrameRate int `json:",omitempty"`
+
+	// Torch state for set torch command.
+	Torch *bool `json:",omitempty"`
+
+	// Focus point for set focus command.
+	FocusPoint *image.Point `json:",omitempty"`
+
+	// Exposure region for set exposure command.
+	ExposureRegion *image.Rectangle `json:",omitempty"`
+
+	// Detection mode for set mode command.
+	Mode string `json:",omitempty"`
 }
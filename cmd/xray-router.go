@@ -20,13 +20,13 @@
 package cmd
 
 import (
-	"bytes"
 	"encoding/json"
-	"image"
 	"net/http"
 	"net/url"
 	"sync"
+	"time"
 
+	"github.com/Sirupsen/logrus"
 	router "github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	minio "github.com/minio/minio-go"
@@ -41,8 +41,8 @@ type xrayHandlers struct {
 	// Used for calculating motion detection.
 	prevSR sensorRecord
 
-	// Represents client response channel, sends client data.
-	clntRespCh chan interface{}
+	// Runs the detections of all the clients.
+	pool *detectionPool
 
 	// Display memory channels.
 	displayCh, displayRecvCh chan bool
@@ -50,104 +50,254 @@ type xrayHandlers struct {
 	// Used for upgrading the incoming HTTP
 	// wconnection into a websocket wconnection.
 	upgrader websocket.Upgrader
+
+	// Currently connected websocket clients.
+	conns map[*wConn]struct{}
+
+	// Tracks open client connections and in-flight
+	// detections, drained on graceful shutdown.
+	connWG, detectWG sync.WaitGroup
+
+	// Tracks burst uploads still in progress.
+	uploadWG sync.WaitGroup
+
+	// Set once graceful shutdown has started, no new
+	// connections or detections are accepted after this.
+	closing bool
 }
 
-var recorderMap = make(map[string]*motionRecorder)
+// Motion is recorded separately for every object class of a client.
+var (
+	recorderMu  sync.Mutex
+	recorderMap = make(map[string]map[string]*motionRecorder)
+)
 
-func getRecordForClient(clientID string) *motionRecorder {
+func getRecordForClient(clientID, class string) *motionRecorder {
+	recorderMu.Lock()
+	defer recorderMu.Unlock()
 	if _, ok := recorderMap[clientID]; !ok {
-		recorderMap[clientID] = &motionRecorder{}
+		recorderMap[clientID] = make(map[string]*motionRecorder)
+	}
+	if _, ok := recorderMap[clientID][class]; !ok {
+		recorderMap[clientID][class] = &motionRecorder{}
 	}
-	mr, _ := recorderMap[clientID]
+	mr, _ := recorderMap[clientID][class]
 	return mr
 }
 
-// Detects face objects on incoming data.
-func (v *xrayHandlers) detectObjects(data []byte) {
+// Returns the motion recorder of a class of a client without
+// creating it, false if no motion was recorded.
+func lookupRecordForClient(clientID, class string) (*motionRecorder, bool) {
+	recorderMu.Lock()
+	defer recorderMu.Unlock()
+	mr, ok := recorderMap[clientID][class]
+	return mr, ok
+}
+
+// Returns the object classes motion is recorded for a client.
+func getRecordedClassesForClient(clientID string) []string {
+	recorderMu.Lock()
+	defer recorderMu.Unlock()
+	var classes []string
+	for class := range recorderMap[clientID] {
+		classes = append(classes, class)
+	}
+	return classes
+}
+
+// Drops the recorded motion history for a client.
+func resetRecordForClient(clientID string) {
+	recorderMu.Lock()
+	delete(recorderMap, clientID)
+	recorderMu.Unlock()
+}
+
+// Detects face objects on incoming data received at the given time,
+// the result is written back to the client along with the
+// backpressure bp if any.
+func (v *xrayHandlers) detectObjects(wc *wConn, data []byte, received time.Time, bp *XrayBackpressure) {
+	log := wc.log
 	defer func() {
 		if r := recover(); r != nil {
-			errorIf(r.(error), "Recovered from a panic in detectObjects")
+			entryErrorIf(log, r.(error), "Recovered from a panic in detectObjects")
 		}
 	}()
+	defer globalMetrics.ObserveDetection(time.Now())
 
 	var fr frameRecord
 	if err := json.Unmarshal(data, &fr); err != nil {
-		errorIf(err, "Unable to unmarshal incoming frame record")
-		v.clntRespCh <- XrayResult{
-			Zoom: -1,
-		}
+		sampledErrorIf(log, err, "Unable to unmarshal incoming frame record")
+		wc.writeResult(XrayResult{Zoom: -1}, bp)
 		return
 	}
 
+	log = log.WithFields(logrus.Fields{
+		logFieldClientID: fr.ClientID,
+		logFieldFrameID:  fr.Frame.ID,
+	})
+
+	wc.session.recordFrame(fr.ClientID)
+	globalMetrics.framesReceived.Inc(fr.ClientID)
+	globalMetrics.facesPerFrame.Observe(float64(len(fr.Faces)))
+	globalMetrics.barcodesPerFrame.Observe(float64(len(fr.Barcodes)))
+	for _, o := range fr.Objects {
+		globalMetrics.objectsReported.Inc(metricClass(o.Class))
+	}
+
 	imgRect, frameID, err := fr.GetFullFrameRect()
 	if err != nil {
-		errorIf(err, "Unable to get image rect")
-		v.clntRespCh <- XrayResult{
-			Zoom: -1,
-		}
+		sampledErrorIf(log, err, "Unable to get image rect")
+		wc.writeResult(XrayResult{Zoom: -1}, bp)
 		return
 	}
 
-	var motionDetected bool
-	var optimalZoomFactor = -1
-	if fr.Faces != nil {
-		var faces []image.Rectangle
-		faces, err = fr.GetFaceRectangles()
-		if err != nil {
-			errorIf(err, "Unable to get face rectangles")
-			v.clntRespCh <- XrayResult{
-				Zoom: -1,
-			}
-			return
-		}
+	now := time.Now()
 
-		// Get recorded frames.
-		mr := getRecordForClient(fr.ClientID)
-		mr.Append(&fr)
+	objects, err := fr.GetObjectRectangles(getMinObjectConfidence())
+	if err != nil {
+		sampledErrorIf(log, err, "Unable to get object rectangles")
+		wc.writeResult(XrayResult{Zoom: -1}, bp)
+		return
+	}
 
-		// Check for motion detection.
-		motionDetected = mr.DetectMotion()
+	if fr.Objects != nil {
+		addTrackedClasses(fr.ClientID, objects)
+	}
 
-		// Calculate optimal zoom factor for faces.
-		optimalZoomFactor = calculateOptimalZoomFactor(faces, imgRect)
+	// Pair the frame record with the binary frame sent along with
+	// it, if any, decoded once for all the server side analyses.
+	var binary *frameImage
+	trigger := received
+	if bf, ok := wc.frames.Match(received, maxFrameAge); ok {
+		binary = &frameImage{data: bf.data}
+		trigger = bf.time
+	}
 
-	} else if fr.Barcodes != nil {
-		var barcodes []image.Rectangle
-		barcodes, err = fr.GetBarcodeRectangles()
-		if err != nil {
-			errorIf(err, "Unable to get barcode rectangles")
-			v.clntRespCh <- XrayResult{
-				Zoom: -1,
-			}
-			return
+	// Detect faces on the server for clients which report none.
+	if faces, ok := detectFaces(log, &fr, binary, imgRect, now); ok {
+		objects[classFace] = faces
+		fr.setDetectedFaces(faces)
+	}
+
+	// Route every class of objects present to its own analysis.
+	triggers := make(map[string]bool)
+	var events []XrayEvent
+	for _, class := range sortedClasses(objects) {
+		result := getClassAnalyzer(class)(log, &fr, class, objects[class], now)
+		if result.motionDetected {
+			triggers[class] = true
 		}
+		events = append(events, result.events...)
+	}
 
-		// Motion is detected relevance is on for barcodes.
-		motionDetected = len(barcodes) > 0
+	// Read the digits printed on labels and barcodes.
+	for _, event := range readLabels(log, &fr, binary, objects, imgRect, now) {
+		triggers[event.Class] = true
+		events = append(events, event)
+	}
 
-		// Calculate optimal zoom factor for barcodes.
-		optimalZoomFactor = calculateOptimalZoomFactor(barcodes, imgRect)
+	// Score the faces so that the best recent frame is uploaded
+	// instead of the frame which crossed the motion threshold.
+	scorer := getBestShotScorerForClient(fr.ClientID)
+	scorer.Add(&fr, frameID, imgRect, binary, now)
+
+	// Calculate optimal zoom factor and framing for the highest
+	// priority class of objects present.
+	zoomObjects := selectZoomObjects(objects, getZoomPriority())
+	optimalZoomFactor := calculateOptimalZoomFactor(zoomObjects, imgRect)
+	zoomRatio, zoomRatioOK := calculateZoomRatio(zoomObjects, imgRect)
+	framing := getFramerForClient(fr.ClientID).Frame(zoomObjects, imgRect)
+
+	// Smooth the zoom factor across frames.
+	optimalZoomFactor = getZoomControllerForClient(fr.ClientID).UpdateFactor(optimalZoomFactor, now)
+
+	// Map the continuous zoom into the zoom range of the device.
+	zoomRatio = wc.session.mapZoomRatio(zoomRatio, zoomRatioOK, fr.GetDeviceZoom(), now)
+
+	// Prepare the snapshot of binary frames, the people recognized
+	// are needed to apply the client policy before uploading.
+	var objectName string
+	var snap *snapshot
+	if len(triggers) > 0 {
+		objectName = genObjectName()
+		snap = newSnapshot(log, objectName, &fr, binary, imgRect)
+		events = applyClientPolicy(log, &fr, snap, events, triggers)
 	}
+	motionDetected := len(triggers) > 0
 
 	pp := &url.URL{}
+	var bestShot *XrayBestShot
+	var burst *XrayBurst
+	var thumbnail string
+	var crops []XrayCrop
 	if motionDetected {
-		// Generate POST presigned URL.
-		pp, err = v.newPresignedURL(genObjectName())
-		if err != nil {
-			errorIf(err, "Unable to generate presigned post policy")
-			v.clntRespCh <- XrayResult{
-				Zoom: -1,
-			}
+		globalMetrics.motionTriggers.Inc(fr.ClientID)
+		bestShot = scorer.Best(now)
+
+		// Upload the snapshot of binary frames along with its
+		// thumbnail and face crops, other clients upload the
+		// snapshot to a presigned URL.
+		if snap != nil {
+			v.uploadSnapshot(log, snap)
+			thumbnail, crops = snap.thumbnail, snap.crops
+		} else if pp, err = v.newPresignedURL(objectName); err != nil {
+			globalMetrics.presignFailures.Inc("")
+			entryErrorIf(log, err, "Unable to generate presigned post policy")
+			wc.writeResult(XrayResult{Zoom: -1}, bp)
 			return
 		}
+	} else {
+		objectName = ""
 	}
 
-	// Send the data to client.
-	v.clntRespCh <- XrayResult{
-		FrameID: frameID,
-		Zoom:    optimalZoomFactor,
-		URL:     pp.String(),
+	// Fill in the events for this frame.
+	for i := range events {
+		events[i].ClientID = fr.ClientID
+		events[i].FrameID = frameID
+		events[i].Time = now.UTC()
+		events[i].ObjectName = objectName
+		events[i].Thumbnail = thumbnail
+		if events[i].Class == classFace {
+			events[i].Crops = crops
+		}
+	}
+
+	// Capture the frames around the trigger.
+	if motionDetected {
+		burst, err = v.startBurst(wc, burstManifest{
+			ClientID: fr.ClientID,
+			FrameID:  frameID,
+			Time:     now.UTC(),
+			Trigger:  objectName,
+			Events:   events,
+		}, trigger)
+		if err != nil {
+			globalMetrics.presignFailures.Inc("")
+			entryErrorIf(log, err, "Unable to start burst capture")
+		}
 	}
+
+	wc.session.recordResult(optimalZoomFactor, motionDetected)
+
+	// Publish events for this frame.
+	for i := range events {
+		if burst != nil {
+			events[i].Manifest = burst.Manifest
+		}
+		globalEventLog.Publish(events[i])
+	}
+
+	// Send the data to client.
+	wc.writeResult(XrayResult{
+		FrameID:   frameID,
+		Zoom:      optimalZoomFactor,
+		ZoomRatio: zoomRatio,
+		URL:       pp.String(),
+		BestShot:  bestShot,
+		Burst:     burst,
+		Framing:   framing,
+		Events:    events,
+	}, bp)
 }
 
 // Detect detects metadata about the incoming data.
@@ -158,57 +308,127 @@ func (v *xrayHandlers) Detect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	wc := wConn{wconn}
-	defer wc.Close()
+	wc := newWConn(wconn, r.RemoteAddr)
+	if !v.addConn(wc) {
+		wc.Close()
+		return
+	}
+	defer v.removeConn(wc)
+
+	wc.log.WithField("remote", r.RemoteAddr).Info("Client connected")
+	defer wc.log.Info("Client disconnected")
 
 	// Waiting on incoming reads.
 	for {
 		mt, data, err := wc.ReadMessage()
 		if err != nil {
-			errorIf(err, "Unable to read incoming message.")
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				entryErrorIf(wc.log, err, "Unable to read incoming message.")
+			}
 			break
 		}
 
+		// Binary frames are buffered for bursts, raw frames are
+		// checked against their header before being buffered.
 		if mt == websocket.BinaryMessage {
-			errorIf(err, "Invalid message type.")
+			if isRawFrame(data) {
+				if err = validateRawFrame(data); err != nil {
+					sampledErrorIf(wc.log, err, "Dropping invalid raw frame")
+					continue
+				}
+			}
+			wc.frames.Add(data, time.Now())
 			continue
 		}
 
 		// Ignore all other forms of incoming data.
-		if bytes.Contains(data, []byte("sensorName")) {
+		keys := parseMessageKeys(data)
+		if keys.has("sensorName") {
 			continue
 		}
 
-		go v.detectObjects(data)
-		wc.WriteMessage(websocket.TextMessage, v.clntRespCh)
+		if isCapabilities(keys) {
+			wc.handleCapabilities(data)
+			continue
+		}
+
+		if isCommandAck(keys) {
+			wc.handleCommandAck(data)
+			continue
+		}
+
+		// Stop processing new frames once shutdown has started.
+		if !v.beginDetect() {
+			break
+		}
+
+		v.pool.Submit(wc, data, time.Now())
 	}
 }
 
+// Registers a new client connection, returns false if
+// the server is shutting down.
+func (v *xrayHandlers) addConn(wc *wConn) bool {
+	v.Lock()
+	defer v.Unlock()
+	if v.closing {
+		return false
+	}
+	v.conns[wc] = struct{}{}
+	v.connWG.Add(1)
+	return true
+}
+
+// Unregisters and closes a client connection.
+func (v *xrayHandlers) removeConn(wc *wConn) {
+	clientID := wc.session.ClientID()
+	v.Lock()
+	delete(v.conns, wc)
+	v.Unlock()
+	v.pool.Remove(wc)
+
+	// Drop the metrics, detection schedule and detector configuration
+	// of the client once its last connection closed.
+	if clientID != "" && len(v.connsForClient(clientID)) == 0 {
+		globalMetrics.DeleteClient(clientID)
+		resetDetectionSchedulerForClient(clientID)
+		resetDetectorForClient(clientID)
+	}
+	wc.commands.stop()
+	wc.frames.Close()
+	wc.Close()
+	v.connWG.Done()
+}
+
+// Marks the start of a detection, returns false if the
+// server is shutting down.
+func (v *xrayHandlers) beginDetect() bool {
+	v.Lock()
+	defer v.Unlock()
+	if v.closing {
+		return false
+	}
+	v.detectWG.Add(1)
+	return true
+}
+
 // Initialize a new xray handlers.
 func newXRayHandlers(clnt *minio.Client) *xrayHandlers {
-	return &xrayHandlers{
+	v := &xrayHandlers{
 		minioClient: clnt,
-		clntRespCh:  make(chan interface{}, 15000),
+		conns:       make(map[*wConn]struct{}),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true
 			},
 		}, // use default options
 	}
+	v.pool = newDetectionPool(getDetectWorkers(), getDetectQueueSize(), getDropPolicy(), v.detectObjects, v.detectWG.Done)
+	return v
 }
 
 // Configure xray handler.
-func configureXrayHandler(mux *router.Router) http.Handler {
-	// Register all xray handlers.
-	registerXRayRouter(mux)
-
-	// Register additional routers if any.
-	return mux
-}
-
-// Register xray router.
-func registerXRayRouter(mux *router.Router) {
-
+func configureXrayHandler(mux *router.Router) (http.Handler, *xrayHandlers) {
 	// Initialize minio client.
 	clnt, err := newMinioClient()
 	fatalIf(err, "Unable to initialize minio client")
@@ -216,6 +436,20 @@ func registerXRayRouter(mux *router.Router) {
 	// Initialize xray handlers.
 	xray := newXRayHandlers(clnt)
 
+	// Register additional routers, these need to be registered
+	// before the catch-all xray router.
+	registerMetricsRouter(mux, xray)
+	registerHealthRouter(mux, xray)
+	registerAdminRouter(mux, xray)
+
+	// Register all xray handlers.
+	registerXRayRouter(mux, xray)
+
+	return mux, xray
+}
+
+// Register xray router.
+func registerXRayRouter(mux *router.Router, xray *xrayHandlers) {
 	// xray Router
 	xrayRouter := mux.NewRoute().PathPrefix("/").Subrouter()
 
@@ -1,9 +1,24 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	gocv "github.com/minio/go-cv"
+	minio "github.com/minio/minio-go"
 )
 
 const jsontext = `{ "frame": { "id": "48", "format": "17", "width": "960", "height": "720", "rotation": "2", "timestamp": "2295" }, "faces": [ { "id": "1", "eulerY": "0.0",
@@ -80,3 +95,245 @@ func testArray(t *testing.T, jsonarray []string) {
 		fmt.Println("For Frame ID", fr.Frame.ID, " zoom =", zoom)
 	}
 }
+
+func TestShutdownClosesClients(t *testing.T) {
+	xray := newXRayHandlers(nil)
+	srv := httptest.NewServer(http.HandlerFunc(xray.Detect))
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	clnt, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Unable to dial xray server: %v", err)
+	}
+	defer clnt.Close()
+
+	// Keep reading so that the close frame is acknowledged.
+	readErrCh := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := clnt.ReadMessage(); err != nil {
+				readErrCh <- err
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = xray.Shutdown(ctx); err != nil {
+		t.Fatalf("Unable to shutdown: %v", err)
+	}
+
+	err = <-readErrCh
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("Expected going away close frame, got %v", err)
+	}
+
+	// New connections must be refused after shutdown.
+	if xray.addConn(&wConn{}) {
+		t.Errorf("Expected connection to be refused after shutdown")
+	}
+}
+
+// detectTestServer serves xray clients against a fake storage which
+// records the uploaded objects.
+type detectTestServer struct {
+	xray    *xrayHandlers
+	srv     *httptest.Server
+	storage *httptest.Server
+
+	mu      sync.Mutex
+	uploads map[string][]byte
+}
+
+// Starts an xray server with bursts disabled.
+func newDetectTestServer(t *testing.T) *detectTestServer {
+	os.Setenv("BURST_PRE_FRAMES", "0")
+	os.Setenv("BURST_POST_FRAMES", "0")
+
+	s := &detectTestServer{uploads: make(map[string][]byte)}
+	s.storage = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		s.uploads[strings.TrimPrefix(r.URL.Path, "/"+globalMinioClntConfig.BucketName()+"/")] = data
+		s.mu.Unlock()
+	}))
+	clnt, err := minio.NewWithRegion(strings.TrimPrefix(s.storage.URL, "http://"), "access", "secret", false, "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.xray = newXRayHandlers(clnt)
+	s.srv = httptest.NewServer(http.HandlerFunc(s.xray.Detect))
+	return s
+}
+
+func (s *detectTestServer) Close() {
+	s.srv.Close()
+	s.storage.Close()
+	os.Unsetenv("BURST_PRE_FRAMES")
+	os.Unsetenv("BURST_POST_FRAMES")
+}
+
+// Connects a websocket client.
+func (s *detectTestServer) dial(t *testing.T) *websocket.Conn {
+	clnt, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Unable to dial xray server: %v", err)
+	}
+	return clnt
+}
+
+// Returns the object uploaded under name once all uploads finished.
+func (s *detectTestServer) uploaded(name string) ([]byte, bool) {
+	s.xray.uploadWG.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.uploads[name]
+	return data, ok
+}
+
+// Sends a frame record preceded by its binary frame if any, returns
+// the result of the detection.
+func detectFrame(t *testing.T, clnt *websocket.Conn, binary []byte, record string) XrayResult {
+	if binary != nil {
+		if err := clnt.WriteMessage(websocket.BinaryMessage, binary); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := clnt.WriteMessage(websocket.TextMessage, []byte(record)); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, data, err := clnt.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parseMessageKeys(data).has("Zoom") {
+			var result XrayResult
+			if err = json.Unmarshal(data, &result); err != nil {
+				t.Fatal(err)
+			}
+			return result
+		}
+	}
+}
+
+// Returns a JPEG encoded gray frame of the given size.
+func testJPEGFrame(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDetectSnapshotUpload(t *testing.T) {
+	s := newDetectTestServer(t)
+	defer s.Close()
+	clnt := s.dial(t)
+	defer clnt.Close()
+
+	// Every new barcode triggers a snapshot.
+	record := func(id int, value string) string {
+		return fmt.Sprintf(`{"client_uuid": "snapshot-upload", "frame": {"id": "%d", "width": "640", "height": "480"},
+			"barcodes": [{"value": "%s", "barcodePt1": {"x": "10", "y": "10"}, "barcodePt2": {"x": "60", "y": "60"}}]}`, id, value)
+	}
+
+	// Clients without binary frames upload to the presigned URL.
+	result := detectFrame(t, clnt, nil, record(1, "first"))
+	if result.URL == "" || len(result.Events) != 1 {
+		t.Fatalf("Expected presigned URL, got %+v", result)
+	}
+
+	// The server uploads the binary frame under the snapshot name,
+	// no presigned URL targets the same object.
+	result = detectFrame(t, clnt, testJPEGFrame(t, 64, 48), record(2, "second"))
+	if result.URL != "" {
+		t.Errorf("Expected no presigned URL with a binary frame, got %s", result.URL)
+	}
+	if len(result.Events) != 1 || result.Events[0].Thumbnail == "" {
+		t.Fatalf("Expected snapshot event with a thumbnail, got %+v", result.Events)
+	}
+	if _, ok := s.uploaded(result.Events[0].ObjectName); !ok {
+		t.Errorf("Expected snapshot %s to be uploaded", result.Events[0].ObjectName)
+	}
+}
+
+func TestDetectServerFaces(t *testing.T) {
+	detector := globalObjectDetector
+	defer func() { globalObjectDetector = detector }()
+	globalObjectDetector = &objectDetector{}
+	if err := globalObjectDetector.Open("../cascade/haar_face_0.xml"); err != nil {
+		t.Fatal(err)
+	}
+
+	const clientID = "detect-server-faces"
+	setDetectorForClient(clientID, detectorConfig{Enabled: true})
+	defer resetDetectorForClient(clientID)
+	defer resetBestShotScorerForClient(clientID)
+
+	lena := &gocv.View{}
+	if err := lena.Load("../contrib/Simd/data/image/face/lena.pgm"); err != nil {
+		t.Fatal(err)
+	}
+	img, err := lena.Image()
+	lena.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newDetectTestServer(t)
+	defer s.Close()
+	clnt := s.dial(t)
+	defer clnt.Close()
+
+	// A barcode triggers the snapshot of a frame whose face is only
+	// detected on the server.
+	b := img.Bounds()
+	result := detectFrame(t, clnt, buf.Bytes(), fmt.Sprintf(`{"client_uuid": "%s", "frame": {"id": "1", "width": "%d", "height": "%d"},
+		"barcodes": [{"value": "server-faces", "barcodePt1": {"x": "0", "y": "0"}, "barcodePt2": {"x": "20", "y": "20"}}]}`,
+		clientID, b.Dx(), b.Dy()))
+	if len(result.Events) != 1 || result.Events[0].ObjectName == "" {
+		t.Fatalf("Expected a snapshot, got %+v", result)
+	}
+
+	// Detected faces are scored and cropped like reported faces.
+	if result.BestShot == nil || len(result.BestShot.Faces) != 1 {
+		t.Errorf("Expected the detected face to be scored, got %+v", result.BestShot)
+	}
+	crop := burstPrefix(result.Events[0].ObjectName) + "face-1.jpg"
+	if _, ok := s.uploaded(crop); !ok {
+		t.Errorf("Expected the detected face crop %s to be uploaded", crop)
+	}
+}
+
+func TestRemoveConnDropsClientState(t *testing.T) {
+	const clientID = "remove-conn"
+	s := newDetectTestServer(t)
+	defer s.Close()
+	clnt := s.dial(t)
+	defer clnt.Close()
+
+	detectFrame(t, clnt, nil, `{"client_uuid": "`+clientID+`", "frame": {"id": "1", "width": "640", "height": "480"}}`)
+	setDetectorForClient(clientID, detectorConfig{Enabled: true})
+	getDetectionSchedulerForClient(clientID)
+
+	// The state of the client is dropped with its last connection.
+	clnt.Close()
+	_, found := lookupDetectionSchedulerForClient(clientID)
+	for i := 0; found && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		_, found = lookupDetectionSchedulerForClient(clientID)
+	}
+	if found {
+		t.Error("Expected the detection scheduler of the client to be dropped")
+	}
+	if getDetectorForClient(clientID).Enabled {
+		t.Error("Expected the detector configuration of the client to be dropped")
+	}
+}
@@ -46,6 +46,42 @@ var (
 			Value: globalXrayKeyFile,
 			Usage: "Path to SSL key file.",
 		},
+		cli.StringFlag{
+			Name:  "log-level",
+			Value: "info",
+			Usage: "Log level, one of debug, info, warning, error.",
+		},
+		cli.StringFlag{
+			Name:  "log-format",
+			Value: logFormatText,
+			Usage: "Log format, one of text, json.",
+		},
+		cli.StringFlag{
+			Name:  "log-file",
+			Usage: "Path to log file, defaults to stderr.",
+		},
+		cli.IntFlag{
+			Name:  "log-max-size",
+			Value: 100,
+			Usage: "Maximum size of log file in MiB before it is rotated.",
+		},
+		cli.IntFlag{
+			Name:  "log-max-backups",
+			Value: 5,
+			Usage: "Maximum number of rotated log files to keep.",
+		},
+		cli.StringFlag{
+			Name:  "event-log",
+			Usage: "Path to append detected events to as JSON lines.",
+		},
+		cli.StringFlag{
+			Name:  "face-gallery",
+			Usage: "Path to the gallery of enrolled faces, kept in memory if not set.",
+		},
+		cli.StringFlag{
+			Name:  "digit-network",
+			Usage: "Path to the Simd digit network file, text recognition is disabled if not set.",
+		},
 	}
 )
 
@@ -62,6 +98,31 @@ USAGE:
 ENVIRONMENT VARIABLES:
   CASCADE:
      LBP_CASCADE: To enable LBP cascade image detector. Defaults to [Haar Cascade].
+     DETECT_SCALE_FACTOR: Ratio between two scales of server side face detection. Defaults to [1.1].
+     DETECT_MIN_SIZE: Smallest face detected on the server in pixels. Defaults to [0].
+     DETECT_MAX_SIZE: Largest face detected on the server in pixels, 0 is unlimited. Defaults to [0].
+     DETECT_GROUP_SIZE: Minimal number of elementary detections of a face. Defaults to [3].
+     DETECT_CHANGE_THRESHOLD: Mean difference per pixel of a frame detected again, static frames reuse the last faces. Defaults to [2].
+     DETECT_STATIC_INTERVAL: Seconds between two detections of a static scene. Defaults to [2].
+  WORKERS:
+     DETECT_WORKERS: Number of frames detected concurrently. Defaults to [number of CPUs].
+     DETECT_QUEUE_SIZE: Number of frames of a client waiting for detection, older frames are dropped. Defaults to [4].
+     DETECT_DROP_POLICY: Frame dropping policy of full queues, one of drop-oldest or keep-latest. Defaults to [drop-oldest].
+  DEBUG:
+     DEBUG: To enable debug logging, overrides --log-level.
+  ADMIN:
+     ADMIN_TOKEN: Bearer token required by the admin API. Admin API is disabled if not set.
+  ZOOM:
+     ZOOM_PRIORITY: Comma separated object classes in the order they drive zoom. Defaults to [face,barcode].
+  BURST:
+     BURST_PRE_FRAMES: Number of frames captured before a motion trigger. Defaults to [5].
+     BURST_POST_FRAMES: Number of frames captured after a motion trigger. Defaults to [5].
+  RECOGNITION:
+     FACE_MATCH_THRESHOLD: Minimum similarity of a face to a person in the gallery. Defaults to [0.85].
+  OBJECTS:
+     OBJECT_MIN_CONFIDENCE: Minimum confidence of reported objects to be considered. Defaults to [0].
+  TEXT:
+     OCR_MIN_CONFIDENCE: Minimum score of a digit to be read, weaker digits are reported as '?'. Defaults to [0.6].
 {{if .Commands}}
 COMMANDS:
   {{range .Commands}}{{join .Names ", "}}{{ "\t" }}{{.Usage}}
@@ -114,11 +175,35 @@ func registerApp() *cli.App {
 	app.Flags = globalFlags
 	app.CustomAppHelpTemplate = xrayHelpTemplate
 	app.Action = func(ctx *cli.Context) error {
+		// Configure logging before anything else is logged.
+		fatalIf(configureLogger(logConfig{
+			Level:      ctx.String("log-level"),
+			Format:     ctx.String("log-format"),
+			File:       ctx.String("log-file"),
+			MaxSize:    int64(ctx.Int("log-max-size")) << 20,
+			MaxBackups: ctx.Int("log-max-backups"),
+		}), "Unable to configure logger.")
+
+		if eventLogPath := ctx.String("event-log"); eventLogPath != "" {
+			fatalIf(globalEventLog.Open(eventLogPath), "Unable to open event log.")
+		}
+
+		if galleryPath := ctx.String("face-gallery"); galleryPath != "" {
+			fatalIf(globalFaceGallery.Open(galleryPath), "Unable to open face gallery.")
+		}
+
+		errorIf(globalObjectDetector.Open(getCascadeFile()), "Unable to load cascade, server side face detection is disabled.")
+
+		if networkPath := ctx.String("digit-network"); networkPath != "" {
+			fatalIf(globalDigitReader.Open(networkPath), "Unable to load digit network.")
+		}
+
 		// Initialize a mux router.
 		mux := router.NewRouter().SkipClean(true)
+		handler, xray := configureXrayHandler(mux)
 		httpServer := &http.Server{
 			Addr:           ctx.String("address"),
-			Handler:        configureXrayHandler(mux),
+			Handler:        handler,
 			MaxHeaderBytes: 1 << 20,
 		}
 
@@ -128,13 +213,23 @@ func registerApp() *cli.App {
 			rlog.Printf("Started listening on ws://%s:%s", host, port)
 		}
 
+		// Drain client sessions on SIGTERM/SIGINT.
+		shutdownDoneCh := make(chan struct{})
+		go handleSignals(httpServer, xray, shutdownDoneCh)
+
 		// Start server, automatically configures TLS if certs are available.
 		cert, key := ctx.String("cert"), ctx.String("key")
 		if isCertFileExists(cert) && isKeyFileExists(key) {
-			fatalIf(httpServer.ListenAndServeTLS(cert, key), "Failed to start xray server.")
+			err = httpServer.ListenAndServeTLS(cert, key)
 		} else {
-			fatalIf(httpServer.ListenAndServe(), "Failed to start xray server.")
+			err = httpServer.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			fatalIf(err, "Failed to start xray server.")
 		}
+
+		// Wait for the graceful shutdown to complete.
+		<-shutdownDoneCh
 		return nil
 	}
 	return app
@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) 2017 Minio, Inc. <https://www.minio.io>
+ *
+ * This file is part of Xray.
+ *
+ * Xray is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program. If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package cmd
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// zoomControllerOptions tunes the per client zoom controller.
+type zoomControllerOptions struct {
+	// A new target zoom which differs from the current target by
+	// at most Hysteresis is only accepted after it has been seen
+	// for Dwell, larger changes are accepted immediately.
+	Hysteresis float64
+	Dwell      time.Duration
+
+	// Maximum change of the zoom per second.
+	MaxRate float64
+
+	// Gains of the PID loop converging towards the target.
+	Kp, Ki, Kd float64
+}
+
+// Default zoom controller options, one zoomBoost step is
+// subject to hysteresis.
+var defaultZoomControllerOptions = zoomControllerOptions{
+	Hysteresis: zoomBoost,
+	Dwell:      500 * time.Millisecond,
+	MaxRate:    4 * zoomBoost,
+	Kp:         4,
+	Ki:         0.5,
+	Kd:         0.1,
+}
+
+// Default options for the continuous zoom ratio controller, changes
+// of up to a quarter of the zoom ratio are subject to hysteresis.
+var defaultZoomRatioControllerOptions = zoomControllerOptions{
+	Hysteresis: 0.25,
+	Dwell:      500 * time.Millisecond,
+	MaxRate:    1,
+	Kp:         4,
+	Ki:         0.5,
+	Kd:         0.1,
+}
+
+// Updates further apart than this reset the controller timing,
+// the client most likely stopped sending frames in between.
+const maxZoomUpdateInterval = 2 * time.Second
+
+// zoomController smooths the per frame optimal zoom factor, so that
+// objects near a zoom box boundary do not make the zoom oscillate.
+type zoomController struct {
+	mutex sync.Mutex
+	opts  zoomControllerOptions
+
+	initialized bool
+	current     float64 // Current zoom as sent to the client.
+	target      float64 // Currently accepted target zoom.
+
+	candidate      float64 // Target waiting for the dwell time.
+	candidateSince time.Time
+
+	integral   float64
+	prevErr    float64
+	lastUpdate time.Time
+}
+
+func newZoomController(opts zoomControllerOptions) *zoomController {
+	return &zoomController{opts: opts}
+}
+
+// Accepts a new target zoom according to hysteresis and dwell time.
+func (zc *zoomController) updateTarget(raw float64, now time.Time) {
+	if raw == zc.target {
+		zc.candidate = zc.target
+		return
+	}
+	if math.Abs(raw-zc.target) > zc.opts.Hysteresis {
+		zc.target = raw
+		zc.candidate = raw
+		return
+	}
+	if raw != zc.candidate {
+		zc.candidate = raw
+		zc.candidateSince = now
+		return
+	}
+	if now.Sub(zc.candidateSince) >= zc.opts.Dwell {
+		zc.target = raw
+	}
+}
+
+// Update feeds the optimal zoom of a frame received at now and
+// returns the smoothed zoom to be sent to the client.
+func (zc *zoomController) Update(raw float64, now time.Time) float64 {
+	zc.mutex.Lock()
+	defer zc.mutex.Unlock()
+
+	if !zc.initialized || now.Sub(zc.lastUpdate) > maxZoomUpdateInterval {
+		zc.initialized = true
+		zc.current = raw
+		zc.target = raw
+		zc.candidate = raw
+		zc.integral = 0
+		zc.prevErr = 0
+		zc.lastUpdate = now
+		return raw
+	}
+
+	zc.updateTarget(raw, now)
+
+	dt := now.Sub(zc.lastUpdate).Seconds()
+	zc.lastUpdate = now
+	if dt <= 0 {
+		return zc.current
+	}
+
+	err := zc.target - zc.current
+	derivative := (err - zc.prevErr) / dt
+	zc.prevErr = err
+
+	rate := zc.opts.Kp*err + zc.opts.Ki*zc.integral + zc.opts.Kd*derivative
+	if math.Abs(rate) > zc.opts.MaxRate {
+		rate = math.Copysign(zc.opts.MaxRate, rate)
+	} else {
+		// Integrate only while not saturated to avoid windup.
+		zc.integral += err * dt
+	}
+
+	next := zc.current + rate*dt
+	// Never overshoot the target.
+	if (err > 0 && next > zc.target) || (err < 0 && next < zc.target) {
+		next = zc.target
+		zc.integral = 0
+	}
+	zc.current = next
+
+	return zc.current
+}
+
+// UpdateFactor is similar to Update for the discrete zoom factors
+// returned by calculateOptimalZoomFactor. Frames with nothing detected
+// are not a zoom to converge to, they are passed through and the
+// controller starts over with the next detection.
+func (zc *zoomController) UpdateFactor(rawZoom int, now time.Time) int {
+	if rawZoom == zoomNothingDetected {
+		zc.mutex.Lock()
+		zc.initialized = false
+		zc.mutex.Unlock()
+		return zoomNothingDetected
+	}
+	return int(math.Floor(zc.Update(float64(rawZoom), now) + 0.5))
+}
+
+var (
+	zoomControllerMu  sync.Mutex
+	zoomControllerMap = make(map[string]*zoomController)
+)
+
+func getZoomControllerForClient(clientID string) *zoomController {
+	zoomControllerMu.Lock()
+	defer zoomControllerMu.Unlock()
+	if _, ok := zoomControllerMap[clientID]; !ok {
+		zoomControllerMap[clientID] = newZoomController(defaultZoomControllerOptions)
+	}
+	return zoomControllerMap[clientID]
+}
+
+// Drops the zoom controller state for a client.
+func resetZoomControllerForClient(clientID string) {
+	zoomControllerMu.Lock()
+	delete(zoomControllerMap, clientID)
+	zoomControllerMu.Unlock()
+}
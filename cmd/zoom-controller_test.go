@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"encoding/json"
+	"image"
+	"testing"
+	"time"
+)
+
+// Interval between frames at 30fps.
+const frameInterval = 33 * time.Millisecond
+
+// Counts how often the direction of the zoom changes.
+func countReversals(zooms []int) int {
+	reversals, direction := 0, 0
+	for i := 1; i < len(zooms); i++ {
+		d := zooms[i] - zooms[i-1]
+		if d == 0 {
+			continue
+		}
+		if direction != 0 && (d > 0) != (direction > 0) {
+			reversals++
+		}
+		direction = d
+	}
+	return reversals
+}
+
+// Replays raw zoom factors through a controller at 30fps.
+func replayZooms(raw []int) []int {
+	zc := newZoomController(defaultZoomControllerOptions)
+	now := time.Now()
+	var zooms []int
+	for _, zoom := range raw {
+		zooms = append(zooms, zc.UpdateFactor(zoom, now))
+		now = now.Add(frameInterval)
+	}
+	return zooms
+}
+
+func TestZoomControllerBoundaryFlip(t *testing.T) {
+	// Face near a zoom box boundary flipping every frame.
+	var raw []int
+	for i := 0; i < 90; i++ {
+		raw = append(raw, zoomBoost*(1+i%2))
+	}
+	zooms := replayZooms(raw)
+	for i, zoom := range zooms {
+		if zoom != zooms[0] {
+			t.Fatalf("Expected stable zoom %d, got %d at frame %d", zooms[0], zoom, i)
+		}
+	}
+}
+
+func TestZoomControllerRateLimit(t *testing.T) {
+	raw := []int{0}
+	for i := 0; i < 60; i++ {
+		raw = append(raw, 3*zoomBoost)
+	}
+	zooms := replayZooms(raw)
+
+	maxStep := int(defaultZoomControllerOptions.MaxRate*frameInterval.Seconds()) + 1
+	for i := 1; i < len(zooms); i++ {
+		if step := zooms[i] - zooms[i-1]; step > maxStep {
+			t.Errorf("Zoom changed by %d at frame %d, maximum is %d", step, i, maxStep)
+		}
+	}
+	if zooms[len(zooms)-1] != 3*zoomBoost {
+		t.Errorf("Expected zoom to converge to %d, got %d", 3*zoomBoost, zooms[len(zooms)-1])
+	}
+}
+
+func TestZoomControllerReplaySingle(t *testing.T) {
+	// Same sequence as TestZoomInSingle, a face growing from the centre.
+	boundingBox := image.Rect(0, 0, 960, 720)
+	face := boundingBox.Inset(350)
+	var raw []int
+	for {
+		zoom := calculateOptimalZoomFactor([]image.Rectangle{face}, boundingBox)
+		// Hold every position for a few frames.
+		for i := 0; i < 10; i++ {
+			raw = append(raw, zoom)
+		}
+		if zoom == 0 {
+			break
+		}
+		face = face.Inset(-10 * zoom)
+	}
+
+	zooms := replayZooms(raw)
+	if n := countReversals(zooms); n != 0 {
+		t.Errorf("Expected monotonic zoom, got %d reversals in %v", n, zooms)
+	}
+}
+
+func TestZoomControllerReplayFrames(t *testing.T) {
+	for _, frames := range [][]string{jsonarray, jsonCenterFace, jsonZoomTwoFaces} {
+		var raw []int
+		for _, jsontext := range frames {
+			var fr frameRecord
+			if err := json.Unmarshal([]byte(jsontext), &fr); err != nil {
+				t.Fatal(err)
+			}
+			boundingBox, _, err := fr.GetFullFrameRect()
+			if err != nil {
+				t.Fatal(err)
+			}
+			faces, err := fr.GetFaceRectangles()
+			if err != nil {
+				t.Fatal(err)
+			}
+			raw = append(raw, calculateOptimalZoomFactor(faces, boundingBox))
+		}
+
+		zooms := replayZooms(raw)
+		if n := countReversals(zooms); n > countReversals(raw) {
+			t.Errorf("Expected at most %d reversals, got %d: raw %v smoothed %v",
+				countReversals(raw), n, raw, zooms)
+		}
+	}
+}
+
+func TestZoomControllerNothingDetected(t *testing.T) {
+	raw := []int{3 * zoomBoost, 3 * zoomBoost, zoomNothingDetected, zoomNothingDetected, zoomBoost}
+	zooms := replayZooms(raw)
+
+	// The sentinel is passed through, never glided towards.
+	expected := []int{3 * zoomBoost, 3 * zoomBoost, zoomNothingDetected, zoomNothingDetected, zoomBoost}
+	for i := range expected {
+		if zooms[i] != expected[i] {
+			t.Errorf("Frame %d: expected zoom %d, got %d", i, expected[i], zooms[i])
+		}
+	}
+}